@@ -1,9 +1,16 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"video-conference/models"
+	"video-conference/protocol"
 )
 
 type Config struct {
@@ -14,6 +21,30 @@ type Config struct {
 	PostgresDSN      string
 	MaxConnections   int
 	WebRTCIceServers []string
+	AppURL           string
+	APIURL           string
+	SMTPHost         string
+	SMTPPort         string
+	SMTPUser         string
+	SMTPPassword     string
+	MailFrom         string
+	CleanupInterval  time.Duration
+	RoomIdleGrace    time.Duration
+	RoomMaxLifetime  time.Duration
+	WSPingInterval   time.Duration
+	WSIdleTimeout    time.Duration
+	WSWriteTimeout   time.Duration
+	WSResumeGrace    time.Duration
+	NodeID           string
+	NodeHeartbeat    time.Duration
+	NodeTTL          time.Duration
+	DrainTimeout     time.Duration
+	MetricsAddr      string
+	WSMaxMessageSize int
+	WSRateLimits     string
+	WSRateStrikes    int
+	Plans            string
+	DefaultPlan      string
 }
 
 func Load() *Config {
@@ -31,7 +62,69 @@ func Load() *Config {
 			"stun:stun3.l.google.com:19302",
 			"stun:stun4.l.google.com:19302",
 		}, ","),
+		AppURL:       getEnv("APP_URL", "http://localhost:3000"),
+		APIURL:       getEnv("API_URL", "http://localhost:3002"),
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUser:     getEnv("SMTP_USER", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		MailFrom:     getEnv("MAIL_FROM", "no-reply@video-conference.local"),
+
+		CleanupInterval: getEnvAsDuration("CLEANUP_INTERVAL", time.Minute),
+		RoomIdleGrace:   getEnvAsDuration("ROOM_IDLE_GRACE", 10*time.Minute),
+		RoomMaxLifetime: getEnvAsDuration("ROOM_MAX_LIFETIME", 24*time.Hour),
+
+		WSPingInterval: getEnvAsDuration("WS_PING_INTERVAL", 25*time.Second),
+		WSIdleTimeout:  getEnvAsDuration("WS_IDLE_TIMEOUT", 60*time.Second),
+		WSWriteTimeout: getEnvAsDuration("WS_WRITE_TIMEOUT", 10*time.Second),
+		WSResumeGrace:  getEnvAsDuration("WS_RESUME_GRACE", 30*time.Second),
+
+		WSMaxMessageSize: getEnvAsInt("WS_MAX_MESSAGE_SIZE", 64*1024),
+		WSRateLimits:     getEnv("WS_RATE_LIMITS", ""),
+		WSRateStrikes:    getEnvAsInt("WS_RATE_STRIKES", 5),
+
+		NodeID:        getEnv("NODE_ID", nodeID()),
+		NodeHeartbeat: getEnvAsDuration("NODE_HEARTBEAT", 10*time.Second),
+		NodeTTL:       getEnvAsDuration("NODE_TTL", 30*time.Second),
+		DrainTimeout:  getEnvAsDuration("DRAIN_TIMEOUT", 20*time.Second),
+		MetricsAddr:   getEnv("METRICS_ADDR", "127.0.0.1:9090"),
+
+		Plans:       getEnv("PLANS", ""),
+		DefaultPlan: getEnv("DEFAULT_PLAN", "free"),
+	}
+}
+
+// Validate rejects settings the server cannot run with: tickers panic on
+// non-positive intervals, a peer pinged less often than the idle timeout,
+// or a node beating less often than its TTL, is dropped while still alive,
+// and a rate limit that is not positive cuts a connection off entirely.
+func (c *Config) Validate() error {
+	positive := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"CLEANUP_INTERVAL", c.CleanupInterval},
+		{"WS_PING_INTERVAL", c.WSPingInterval},
+		{"WS_IDLE_TIMEOUT", c.WSIdleTimeout},
+		{"WS_WRITE_TIMEOUT", c.WSWriteTimeout},
+		{"NODE_HEARTBEAT", c.NodeHeartbeat},
+		{"NODE_TTL", c.NodeTTL},
+	}
+	for _, p := range positive {
+		if p.d <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", p.name, p.d)
+		}
+	}
+	if c.WSPingInterval >= c.WSIdleTimeout {
+		return fmt.Errorf("WS_PING_INTERVAL (%s) must be shorter than WS_IDLE_TIMEOUT (%s)", c.WSPingInterval, c.WSIdleTimeout)
 	}
+	if c.NodeHeartbeat >= c.NodeTTL {
+		return fmt.Errorf("NODE_HEARTBEAT (%s) must be shorter than NODE_TTL (%s)", c.NodeHeartbeat, c.NodeTTL)
+	}
+	if _, err := models.ParseRateLimits(c.WSRateLimits, protocol.IsRequest); err != nil {
+		return fmt.Errorf("WS_RATE_LIMITS: %w", err)
+	}
+	return nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -41,6 +134,19 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// nodeID names this process. The random suffix keeps instances sharing a
+// hostname from taking each other for a previous run of themselves and
+// reclaiming live presence.
+func nodeID() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		name = "local"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return name + "-" + hex.EncodeToString(suffix)
+}
+
 func getEnvAsInt(key string, defaultValue int) int {
 	strValue := getEnv(key, "")
 	if value, err := strconv.Atoi(strValue); err == nil {
@@ -57,6 +163,14 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	strValue := getEnv(key, "")
+	if value, err := time.ParseDuration(strValue); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
 func getEnvAsSlice(key string, defaultValue []string, sep string) []string {
 	strValue := getEnv(key, "")
 	if strValue == "" {
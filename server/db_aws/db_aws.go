@@ -24,6 +24,7 @@ import (
 	"golang.org/x/crypto/argon2"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const (
@@ -181,17 +182,80 @@ func InitDb(dsn string) *gorm.DB {
 		&models.User{},
 		&models.Session{},
 		&models.Room{},
+		&models.RoomCoOwner{},
 		&models.Participant{},
+		&models.RoomAttendee{},
+		&models.RoomOccurrence{},
+		&models.Invitation{},
+		&models.RoomSlugRedirect{},
+		&models.RoomTemplate{},
+		&models.Organization{},
+		&models.OrgMembership{},
+		&models.MeetingSession{},
+		&models.AuditRecord{},
+		&schemaMigration{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 
+	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_rooms_search ON rooms USING GIN ((" + models.RoomSearchVector + "))").Error; err != nil {
+		log.Fatalf("Failed to create search index: %v", err)
+	}
+
+	runOnce(db, "default-org", migrateDefaultOrg)
 	seed.Seed(db)
 
 	return db
 }
 
+// schemaMigration records a data migration that has been applied, so that it
+// runs once per database rather than on every boot.
+type schemaMigration struct {
+	Version   string    `gorm:"primaryKey"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (*schemaMigration) TableName() string { return "schema_migrations" }
+
+func runOnce(db *gorm.DB, version string, migrate func(tx *gorm.DB) error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Serialize nodes booting together; the loser sees the version applied.
+		if err := tx.Exec("LOCK TABLE schema_migrations IN EXCLUSIVE MODE").Error; err != nil {
+			return err
+		}
+		var n int64
+		if err := tx.Model(&schemaMigration{}).Where("version = ?", version).Count(&n).Error; err != nil || n > 0 {
+			return err
+		}
+		if err := migrate(tx); err != nil {
+			return err
+		}
+		return tx.Create(&schemaMigration{Version: version, AppliedAt: time.Now()}).Error
+	})
+	if err != nil {
+		log.Fatalf("Failed to run migration %s: %v", version, err)
+	}
+}
+
+// migrateDefaultOrg moves users and rooms that predate organizations into the
+// default organization. New users are enrolled at registration.
+func migrateDefaultOrg(tx *gorm.DB) error {
+	org := models.Organization{ID: models.DefaultOrgID, Name: "Default", RoomDefaults: models.DefaultRoomSettings()}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&org).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec(`INSERT INTO org_memberships (org_id, user_id, role)
+		SELECT ?, id, ? FROM users WHERE org_id IS NULL
+		ON CONFLICT DO NOTHING`, models.DefaultOrgID, models.OrgMember).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("UPDATE users SET org_id = ? WHERE org_id IS NULL", models.DefaultOrgID).Error; err != nil {
+		return err
+	}
+	return tx.Exec("UPDATE rooms SET org_id = ? WHERE org_id IS NULL", models.DefaultOrgID).Error
+}
+
 func GetOrCreateUser(db *gorm.DB, username string) models.User {
 	var user models.User
 	if err := db.Where("name = ?", username).First(&user).Error; err != nil {
@@ -0,0 +1,220 @@
+package ics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	MethodRequest = "REQUEST"
+	MethodCancel  = "CANCEL"
+	MethodPublish = "PUBLISH"
+)
+
+const prodID = "-//video-conference//scheduler//EN"
+
+type Person struct {
+	Name  string
+	Email string
+}
+
+type Event struct {
+	UID          string
+	Sequence     int
+	Status       string
+	Summary      string
+	Description  string
+	URL          string
+	Start        time.Time
+	End          time.Time
+	Location     *time.Location
+	RRule        string
+	ExDates      []time.Time
+	RecurrenceID *time.Time
+	Organizer    Person
+	Attendees    []Person
+	Stamp        time.Time
+}
+
+type Calendar struct {
+	Method string
+	Name   string
+	Events []Event
+}
+
+func (c Calendar) String() string {
+	var b strings.Builder
+	w := func(line string) { writeFolded(&b, line) }
+
+	w("BEGIN:VCALENDAR")
+	w("VERSION:2.0")
+	w("PRODID:" + prodID)
+	w("CALSCALE:GREGORIAN")
+	if c.Method != "" {
+		w("METHOD:" + c.Method)
+	}
+	if c.Name != "" {
+		w("X-WR-CALNAME:" + escape(c.Name))
+	}
+	seen := make(map[string]bool)
+	for _, e := range c.Events {
+		if e.zoned() && !seen[e.Location.String()] {
+			seen[e.Location.String()] = true
+			writeTimezone(w, e.Location, e.Start)
+		}
+	}
+	for _, e := range c.Events {
+		e.write(w, c.Method)
+	}
+	w("END:VCALENDAR")
+	return b.String()
+}
+
+func (e Event) write(w func(string), method string) {
+	stamp := e.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+	status := e.Status
+	if status == "" {
+		status = "CONFIRMED"
+		if method == MethodCancel {
+			status = "CANCELLED"
+		}
+	}
+
+	w("BEGIN:VEVENT")
+	w("UID:" + e.UID)
+	w("DTSTAMP:" + formatTime(stamp))
+	w("DTSTART" + e.dateTime(e.Start))
+	w("DTEND" + e.dateTime(e.End))
+	if e.RecurrenceID != nil {
+		w("RECURRENCE-ID" + e.dateTime(*e.RecurrenceID))
+	}
+	if e.RRule != "" {
+		w("RRULE:" + e.RRule)
+	}
+	for _, ex := range e.ExDates {
+		w("EXDATE" + e.dateTime(ex))
+	}
+	w(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+	w("STATUS:" + status)
+	w("SUMMARY:" + escape(e.Summary))
+	if e.Description != "" {
+		w("DESCRIPTION:" + escape(e.Description))
+	}
+	if e.URL != "" {
+		w("URL:" + e.URL)
+		w("LOCATION:" + escape(e.URL))
+	}
+	if e.Organizer.Email != "" {
+		w("ORGANIZER" + cnParam(e.Organizer.Name) + ":mailto:" + e.Organizer.Email)
+	}
+	for _, a := range e.Attendees {
+		w("ATTENDEE" + cnParam(a.Name) + ";ROLE=REQ-PARTICIPANT;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:" + a.Email)
+	}
+	w("END:VEVENT")
+}
+
+func (e Event) zoned() bool { return e.Location != nil && e.Location != time.UTC }
+
+// dateTime renders a property value, including its leading separator, either
+// as UTC or as local time with a TZID so recurrences keep their wall-clock
+// time across DST changes.
+func (e Event) dateTime(t time.Time) string {
+	if !e.zoned() {
+		return ":" + formatTime(t)
+	}
+	return ";TZID=" + e.Location.String() + ":" + t.In(e.Location).Format("20060102T150405")
+}
+
+func formatTime(t time.Time) string { return t.UTC().Format("20060102T150405Z") }
+
+// writeTimezone emits a VTIMEZONE describing every offset transition of loc
+// from a year before around until a few years after it.
+func writeTimezone(w func(string), loc *time.Location, around time.Time) {
+	from := time.Date(around.Year()-1, 1, 1, 0, 0, 0, 0, loc)
+	to := time.Date(around.Year()+5, 1, 1, 0, 0, 0, 0, loc)
+
+	w("BEGIN:VTIMEZONE")
+	w("TZID:" + loc.String())
+
+	_, prev := from.Zone()
+	transitions := 0
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		if _, off := next.Zone(); off == prev {
+			continue
+		}
+		lo, hi := day.Unix(), next.Unix()
+		for hi-lo > 1 {
+			mid := (lo + hi) / 2
+			if _, off := time.Unix(mid, 0).In(loc).Zone(); off == prev {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		onset := time.Unix(hi, 0).In(loc)
+		name, off := onset.Zone()
+		writeObservance(w, onset.IsDST(), name, prev, off, time.Unix(hi+int64(prev), 0).UTC())
+		prev = off
+		transitions++
+	}
+	if transitions == 0 {
+		name, off := from.Zone()
+		writeObservance(w, false, name, off, off, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+	w("END:VTIMEZONE")
+}
+
+func writeObservance(w func(string), dst bool, name string, from, to int, onset time.Time) {
+	kind := "STANDARD"
+	if dst {
+		kind = "DAYLIGHT"
+	}
+	w("BEGIN:" + kind)
+	w("DTSTART:" + onset.Format("20060102T150405"))
+	w("TZOFFSETFROM:" + formatOffset(from))
+	w("TZOFFSETTO:" + formatOffset(to))
+	w("TZNAME:" + name)
+	w("END:" + kind)
+}
+
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds/60%60)
+}
+
+func cnParam(name string) string {
+	if name == "" {
+		return ""
+	}
+	return `;CN="` + strings.NewReplacer(`"`, "", "\r", "", "\n", "").Replace(name) + `"`
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func escape(s string) string { return textEscaper.Replace(s) }
+
+// writeFolded splits content lines longer than 75 octets as required by
+// RFC 5545 §3.1, never breaking inside a UTF-8 sequence.
+func writeFolded(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,64 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrQueueFull   = errors.New("mail queue full")
+	ErrQueueClosed = errors.New("mail queue closed")
+)
+
+// Queue sends mail in the background so that a slow or failing mail server
+// never holds up the request that triggered it. Failures are logged.
+type Queue struct {
+	next    Mailer
+	pending chan Message
+	done    sync.WaitGroup
+	mu      sync.RWMutex
+	closed  bool
+}
+
+func NewQueue(next Mailer, size int) *Queue {
+	q := &Queue{next: next, pending: make(chan Message, size)}
+	q.done.Add(1)
+	go q.run()
+	return q
+}
+
+// Send queues msg and returns at once; ctx only has to outlive the call.
+func (q *Queue) Send(_ context.Context, msg Message) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	select {
+	case q.pending <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close sends what is still queued and stops the queue.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	close(q.pending)
+	q.mu.Unlock()
+	q.done.Wait()
+}
+
+func (q *Queue) run() {
+	defer q.done.Done()
+	for msg := range q.pending {
+		if err := q.next.Send(context.Background(), msg); err != nil {
+			log.Printf("mail %q to %s failed: %v", msg.Subject, strings.Join(msg.To, ", "), err)
+		}
+	}
+}
@@ -3,12 +3,16 @@ package main
 import (
 	"context"
 	"log"
+	_ "time/tzdata"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
 
 	"video-conference/config"
 	"video-conference/db_aws"
+	"video-conference/mailer"
+	"video-conference/models"
+	"video-conference/protocol"
 	"video-conference/repositories"
 	"video-conference/server"
 	"video-conference/services"
@@ -19,6 +23,9 @@ func main() {
 		log.Println("warning: .env file not found – falling back to shell env")
 	}
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
 
 	db := db_aws.InitDb(cfg.PostgresDSN)
 
@@ -29,23 +36,73 @@ func main() {
 	redisClient := redis.NewClient(redisOpts)
 	defer redisClient.Close()
 
-	ctx := context.Background()
-	if err := redisClient.FlushDB(ctx).Err(); err != nil {
-		log.Fatalf("redis FlushDB: %v", err)
-	}
-	log.Println("redis: database flushed")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	userRepo := repositories.NewUserRepository(db)
 	roomRepo := repositories.NewRoomRepository(redisClient, db)
+	invRepo := repositories.NewInvitationRepository(db)
+	tplRepo := repositories.NewTemplateRepository(db)
+	orgRepo := repositories.NewOrgRepository(db)
+	meetingRepo := repositories.NewMeetingRepository(db)
+	auditRepo := repositories.NewAuditRepository(db)
+
+	var mail mailer.Mailer = mailer.LogMailer{}
+	if cfg.SMTPHost != "" {
+		mail = mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPassword, cfg.MailFrom)
+	}
+	mailQueue := mailer.NewQueue(mail, 256)
+	defer mailQueue.Close()
+	mail = mailQueue
+
+	plans, err := models.ParsePlans(cfg.Plans)
+	if err != nil {
+		log.Fatalf("PLANS: %v", err)
+	}
+	rateLimits, err := models.ParseRateLimits(cfg.WSRateLimits, protocol.IsRequest)
+	if err != nil {
+		log.Fatalf("WS_RATE_LIMITS: %v", err)
+	}
+	quotaSvc := services.NewQuotaService(roomRepo, userRepo, orgRepo, plans, cfg.DefaultPlan)
+	reportSvc := services.NewReportService(roomRepo, userRepo, meetingRepo, mail)
 
 	authSvc := services.NewAuthService(userRepo, cfg.JWTSecret)
 	wsSvc := services.NewWebSocketService(
 		roomRepo,
 		userRepo,
+		quotaSvc,
+		reportSvc,
+		auditRepo,
 		cfg.WebRTCIceServers,
 		cfg.MaxConnections,
+		cfg.WSPingInterval,
+		cfg.WSIdleTimeout,
+		cfg.WSWriteTimeout,
+		cfg.WSResumeGrace,
+		cfg.NodeID,
+		rateLimits,
+		int64(cfg.WSMaxMessageSize),
+		cfg.WSRateStrikes,
+	)
+	wsSvc.Reclaim(ctx)
+	go wsSvc.RunHeartbeat(ctx, cfg.NodeHeartbeat, cfg.NodeTTL)
+
+	cleanupSvc := services.NewCleanupService(
+		roomRepo,
+		wsSvc,
+		cfg.CleanupInterval,
+		cfg.RoomIdleGrace,
+		cfg.RoomMaxLifetime,
 	)
+	go cleanupSvc.Run(ctx)
+
+	schedSvc := services.NewScheduleService(roomRepo, userRepo, mail, cfg.AppURL, cfg.APIURL)
+	invSvc := services.NewInvitationService(invRepo, roomRepo, userRepo, mail, cfg.AppURL)
+	roomSvc := services.NewRoomService(roomRepo, userRepo, orgRepo)
+	go roomSvc.BackfillPersonalRooms(ctx)
+	tplSvc := services.NewTemplateService(tplRepo, roomRepo, orgRepo)
+	orgSvc := services.NewOrgService(orgRepo, userRepo)
 
-	srv := server.New(cfg, authSvc, wsSvc, roomRepo, userRepo)
+	srv := server.New(cfg, authSvc, wsSvc, schedSvc, invSvc, roomSvc, tplSvc, orgSvc, quotaSvc, reportSvc, roomRepo, userRepo)
 	srv.Start()
 }
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationDeclined InvitationStatus = "declined"
+)
+
+type Invitation struct {
+	ID          uuid.UUID        `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RoomID      uuid.UUID        `gorm:"type:uuid;not null;index"                       json:"room_id"`
+	Room        Room             `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE" json:"room"`
+	InviterID   uuid.UUID        `gorm:"type:uuid;not null"                             json:"inviter_id"`
+	InviteeID   *uuid.UUID       `gorm:"type:uuid;index"                                json:"invitee_id,omitempty"`
+	Email       string           `gorm:"size:100;not null;default:'';index"             json:"email,omitempty"`
+	Status      InvitationStatus `gorm:"size:16;not null;default:'pending'"             json:"status"`
+	CreatedAt   time.Time        `gorm:"not null;default:now()"                         json:"created_at"`
+	RespondedAt *time.Time       `json:"responded_at,omitempty"`
+}
+
+func (*Invitation) TableName() string { return "invitations" }
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AnyMessage keys the limit shared by every message on a connection.
+const AnyMessage = "*"
+
+// RateLimit lets a connection send Burst messages at once, refilled at Rate
+// messages per second.
+type RateLimit struct {
+	Type  string  `json:"type"`
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func DefaultRateLimits() map[string]RateLimit {
+	return map[string]RateLimit{
+		AnyMessage:      {Type: AnyMessage, Rate: 50, Burst: 150},
+		"chat-message":  {Type: "chat-message", Rate: 2, Burst: 5},
+		"ice-candidate": {Type: "ice-candidate", Rate: 20, Burst: 100},
+		"offer":         {Type: "offer", Rate: 2, Burst: 10},
+		"answer":        {Type: "answer", Rate: 2, Burst: 10},
+		"heartbeat":     {Type: "heartbeat", Rate: 1, Burst: 3},
+		"raise-hand":    {Type: "raise-hand", Rate: 1, Burst: 3},
+	}
+}
+
+// ParseRateLimits overlays a JSON array of limits onto the built-in ones.
+// Each entry must name AnyMessage or a message type known reports, and
+// allow a positive rate and burst.
+func ParseRateLimits(raw string, known func(typ string) bool) (map[string]RateLimit, error) {
+	limits := DefaultRateLimits()
+	if raw == "" {
+		return limits, nil
+	}
+	var list []RateLimit
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil, err
+	}
+	for i, l := range list {
+		switch {
+		case l.Type == "":
+			return nil, fmt.Errorf("limit %d: type is required", i)
+		case l.Type != AnyMessage && !known(l.Type):
+			return nil, fmt.Errorf("limit %d: unknown message type %q", i, l.Type)
+		case l.Rate <= 0:
+			return nil, fmt.Errorf("limit %d (%s): rate must be positive, got %g", i, l.Type, l.Rate)
+		case l.Burst <= 0:
+			return nil, fmt.Errorf("limit %d (%s): burst must be positive, got %d", i, l.Type, l.Burst)
+		}
+		limits[l.Type] = l
+	}
+	return limits, nil
+}
+
+const (
+	AuditRateLimited   = "ws-rate-limited"
+	AuditOversizeFrame = "ws-oversize-frame"
+)
+
+// AuditRecord notes something a user did that an operator may need to look
+// into later, such as being disconnected for flooding a room.
+type AuditRecord struct {
+	ID        uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Action    string     `gorm:"not null;index"                                 json:"action"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index"                       json:"user_id"`
+	RoomID    *uuid.UUID `gorm:"type:uuid;index"                                json:"room_id,omitempty"`
+	NodeID    string     `json:"node_id"`
+	Detail    string     `json:"detail"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (*AuditRecord) TableName() string { return "audit_records" }
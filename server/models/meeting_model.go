@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MeetingSession is one continuous use of a room, from the first join until
+// the meeting is ended or the room goes idle.
+type MeetingSession struct {
+	ID               uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RoomID           uuid.UUID  `gorm:"type:uuid;not null;index"                       json:"room_id"`
+	Room             Room       `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE" json:"-"`
+	StartedAt        time.Time  `gorm:"not null"                                       json:"started_at"`
+	EndedAt          *time.Time `json:"ended_at,omitempty"`
+	PeakParticipants int        `gorm:"not null;default:0"                             json:"peak_participants"`
+	ChatMessages     int        `gorm:"not null;default:0"                             json:"chat_messages"`
+	HandsRaised      int        `gorm:"not null;default:0"                             json:"hands_raised"`
+	PollsRun         int        `gorm:"not null;default:0"                             json:"polls_run"`
+}
+
+func (*MeetingSession) TableName() string { return "meeting_sessions" }
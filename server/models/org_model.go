@@ -0,0 +1,68 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultOrgID is the organization that pre-existing users and rooms were
+// migrated into, and that users without a home organization fall back to.
+var DefaultOrgID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+var ErrOrgLimit = errors.New("exceeds organization limits")
+
+type Organization struct {
+	ID              uuid.UUID    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name            string       `gorm:"size:100;not null"                              json:"name"`
+	RoomDefaults    RoomSettings `gorm:"type:jsonb;serializer:json;not null;default:'{}'" json:"room_defaults"`
+	MaxParticipants int          `gorm:"not null;default:0"                             json:"max_participants"`
+	Plan            string       `gorm:"size:32;not null;default:''"                    json:"plan"`
+	CreatedAt       time.Time    `gorm:"not null;default:now()"                         json:"created_at"`
+	UpdatedAt       time.Time    `gorm:"not null;default:now()"                         json:"updated_at"`
+}
+
+func (*Organization) TableName() string { return "organizations" }
+
+// DefaultSettings is the starting point for new rooms in the organization.
+func (o *Organization) DefaultSettings() RoomSettings {
+	s := o.RoomDefaults
+	if s.isZero() {
+		s = DefaultRoomSettings()
+	}
+	if o.MaxParticipants > 0 && s.MaxParticipants > o.MaxParticipants {
+		s.MaxParticipants = o.MaxParticipants
+	}
+	return s
+}
+
+func (o *Organization) CheckLimits(s RoomSettings) error {
+	if o.MaxParticipants > 0 && s.MaxParticipants > o.MaxParticipants {
+		return ErrOrgLimit
+	}
+	return nil
+}
+
+type OrgRole string
+
+const (
+	OrgOwner  OrgRole = "owner"
+	OrgAdmin  OrgRole = "admin"
+	OrgMember OrgRole = "member"
+)
+
+func (r OrgRole) Valid() bool { return r == OrgOwner || r == OrgAdmin || r == OrgMember }
+
+func (r OrgRole) CanAdmin() bool { return r == OrgOwner || r == OrgAdmin }
+
+type OrgMembership struct {
+	OrgID     uuid.UUID    `gorm:"primaryKey;type:uuid"                            json:"org_id"`
+	Org       Organization `gorm:"foreignKey:OrgID;constraint:OnDelete:CASCADE"    json:"-"`
+	UserID    uuid.UUID    `gorm:"primaryKey;type:uuid;index"                      json:"user_id"`
+	User      User         `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"   json:"-"`
+	Role      OrgRole      `gorm:"size:16;not null;default:'member'"               json:"role"`
+	CreatedAt time.Time    `gorm:"not null;default:now()"                          json:"created_at"`
+}
+
+func (*OrgMembership) TableName() string { return "org_memberships" }
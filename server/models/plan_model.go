@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Plan limits are per calendar month (UTC) where applicable; zero means
+// unlimited.
+type Plan struct {
+	Name                   string `json:"name"`
+	RoomsPerMonth          int    `json:"roomsPerMonth"`
+	ConcurrentRooms        int    `json:"concurrentRooms"`
+	ConcurrentParticipants int    `json:"concurrentParticipants"`
+	MinutesPerMonth        int    `json:"minutesPerMonth"`
+}
+
+func DefaultPlans() map[string]Plan {
+	return map[string]Plan{
+		"free":       {Name: "free", RoomsPerMonth: 100, ConcurrentRooms: 2, ConcurrentParticipants: 50, MinutesPerMonth: 10000},
+		"pro":        {Name: "pro", RoomsPerMonth: 1000, ConcurrentRooms: 10, ConcurrentParticipants: 500, MinutesPerMonth: 100000},
+		"enterprise": {Name: "enterprise"},
+	}
+}
+
+// ParsePlans overlays a JSON array of plans onto the built-in ones.
+func ParsePlans(raw string) (map[string]Plan, error) {
+	plans := DefaultPlans()
+	if raw == "" {
+		return plans, nil
+	}
+	var list []Plan
+	if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil, err
+	}
+	for _, p := range list {
+		plans[p.Name] = p
+	}
+	return plans, nil
+}
+
+type Usage struct {
+	PeriodStart            time.Time `json:"periodStart"`
+	RoomsCreated           int64     `json:"roomsCreated"`
+	ConcurrentRooms        int       `json:"concurrentRooms"`
+	ConcurrentParticipants int       `json:"concurrentParticipants"`
+	Minutes                int64     `json:"minutes"`
+}
@@ -4,33 +4,169 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type Room struct {
-	ID              uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	OwnerID         uuid.UUID `gorm:"type:uuid;not null;index"             json:"owner_id"`
-	Title           string    `gorm:"size:100;not null"                   json:"title"`
-	Description     string    `gorm:"size:255;not null"                   json:"description"`
-	MaxParticipants int       `gorm:"not null;default:10"                json:"max_participants"`
-	IsActive        bool      `gorm:"not null;default:true"              json:"is_active"`
-	CreatedAt       time.Time `gorm:"not null;default:now()"             json:"created_at"`
-	UpdatedAt       time.Time `gorm:"not null;default:now()"             json:"updated_at"`
+	ID              uuid.UUID     `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	OwnerID         uuid.UUID     `gorm:"type:uuid;not null;index"             json:"owner_id"`
+	CoOwners        []RoomCoOwner `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE" json:"co_owners"`
+	OrgID           *uuid.UUID    `gorm:"type:uuid;index"                    json:"org_id,omitempty"`
+	Shared          bool          `gorm:"not null;default:false"             json:"shared"`
+	ParentID        *uuid.UUID    `gorm:"type:uuid;index"                    json:"parent_id,omitempty"`
+	Slug            *string       `gorm:"size:48;uniqueIndex"                json:"slug,omitempty"`
+	Title           string        `gorm:"size:100;not null"                   json:"title"`
+	Description     string        `gorm:"size:255;not null"                   json:"description"`
+	Tags            []string      `gorm:"type:jsonb;serializer:json;not null;default:'[]'" json:"tags"`
+	Discoverable    bool          `gorm:"not null;default:false"             json:"discoverable"`
+	Type            RoomType      `gorm:"size:16;not null;default:'meeting'" json:"type"`
+	MaxParticipants int           `gorm:"not null;default:10"                json:"max_participants"`
+	MaxAttendees    int           `gorm:"not null;default:0"                 json:"max_attendees"`
+	Settings        RoomSettings  `gorm:"type:jsonb;serializer:json;not null;default:'{}'" json:"settings"`
+	IsActive        bool          `gorm:"not null;default:true"              json:"is_active"`
+	EmptySince      *time.Time    `json:"-"`
+	InviteOnly      bool          `gorm:"not null;default:false"             json:"invite_only"`
+	Permanent       bool          `gorm:"not null;default:false"             json:"permanent"`
+	StartsAt        *time.Time    `gorm:"index"                              json:"starts_at,omitempty"`
+	EndsAt          *time.Time    `json:"ends_at,omitempty"`
+	TimeZone        string        `gorm:"size:64;not null;default:'UTC'"     json:"time_zone"`
+	RRule           string        `gorm:"size:255;not null;default:''"       json:"rrule,omitempty"`
+	Sequence        int           `gorm:"not null;default:0"                 json:"-"`
+	CreatedAt       time.Time     `gorm:"not null;default:now()"             json:"created_at"`
+	UpdatedAt       time.Time     `gorm:"not null;default:now()"             json:"updated_at"`
 }
 
 func (*Room) TableName() string { return "rooms" }
 
+// Rooms created before settings existed, or by code paths that do not care
+// about them, fall back to the defaults sized to their participant cap.
+func (r *Room) BeforeCreate(*gorm.DB) error {
+	r.normalizeSettings()
+	if r.Tags == nil {
+		r.Tags = []string{}
+	}
+	return nil
+}
+
+func (r *Room) AfterFind(*gorm.DB) error {
+	r.normalizeSettings()
+	return nil
+}
+
+func (r *Room) normalizeSettings() {
+	if r.Settings.isZero() {
+		r.Settings = DefaultRoomSettings()
+		if r.MaxParticipants > 0 {
+			r.Settings.MaxParticipants = r.MaxParticipants
+		}
+	}
+}
+
+// IsManager reports whether userID may edit, close and host the room.
+func (r *Room) IsManager(userID string) bool {
+	return r.OwnerID.String() == userID || r.IsCoOwner(userID)
+}
+
+func (r *Room) IsCoOwner(userID string) bool {
+	for _, c := range r.CoOwners {
+		if c.UserID.String() == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Place puts a new room into org, starting from its default settings.
+func (r *Room) Place(org *Organization) {
+	r.OrgID = &org.ID
+	r.ApplySettings(org.DefaultSettings())
+}
+
+func (r *Room) ApplySettings(s RoomSettings) {
+	r.Settings = s
+	r.MaxParticipants = s.MaxParticipants
+}
+
+// RoomSearchVector is the full-text document searched by the room search
+// endpoint. It is also the expression behind the idx_rooms_search GIN index,
+// so both must stay identical.
+const RoomSearchVector = `setweight(to_tsvector('english', title), 'A') || ` +
+	`setweight(jsonb_to_tsvector('simple', tags, '["string"]'), 'B') || ` +
+	`setweight(to_tsvector('english', description), 'C')`
+
+type RoomType string
+
+const (
+	RoomMeeting RoomType = "meeting"
+	RoomWebinar RoomType = "webinar"
+)
+
+func (t RoomType) Valid() bool { return t == RoomMeeting || t == RoomWebinar }
+
+type RoomSlugRedirect struct {
+	Slug      string    `gorm:"primaryKey;size:48"                json:"slug"`
+	RoomID    uuid.UUID `gorm:"type:uuid;not null;index"          json:"room_id"`
+	Room      Room      `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE" json:"-"`
+	CreatedAt time.Time `gorm:"not null;default:now()"            json:"created_at"`
+}
+
+func (*RoomSlugRedirect) TableName() string { return "room_slug_redirects" }
+
+type RoomCoOwner struct {
+	RoomID    uuid.UUID `gorm:"primaryKey;type:uuid"           json:"room_id"`
+	UserID    uuid.UUID `gorm:"primaryKey;type:uuid;index"     json:"user_id"`
+	CreatedAt time.Time `gorm:"not null;default:now()"         json:"created_at"`
+}
+
+func (*RoomCoOwner) TableName() string { return "room_co_owners" }
+
+type RoomAttendee struct {
+	ID     uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RoomID uuid.UUID `gorm:"type:uuid;not null;index"                       json:"room_id"`
+	Room   Room      `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE" json:"-"`
+	Email  string    `gorm:"size:100;not null;index"                        json:"email"`
+}
+
+func (*RoomAttendee) TableName() string { return "room_attendees" }
+
+type RoomOccurrence struct {
+	ID            uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RoomID        uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_room_occurrence" json:"room_id"`
+	Room          Room       `gorm:"foreignKey:RoomID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE" json:"-"`
+	OriginalStart time.Time  `gorm:"not null;uniqueIndex:idx_room_occurrence"        json:"original_start"`
+	StartsAt      *time.Time `json:"starts_at,omitempty"`
+	EndsAt        *time.Time `json:"ends_at,omitempty"`
+	Title         string     `gorm:"size:100;not null;default:''"                   json:"title,omitempty"`
+	Description   string     `gorm:"size:255;not null;default:''"                   json:"description,omitempty"`
+	Cancelled     bool       `gorm:"not null;default:false"                         json:"cancelled"`
+	Sequence      int        `gorm:"not null;default:0"                             json:"-"`
+}
+
+func (*RoomOccurrence) TableName() string { return "room_occurrences" }
+
 type Participant struct {
 	ID        uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
 	RoomID    uuid.UUID  `gorm:"type:uuid;not null;index"                       json:"room_id"`
 	UserID    uuid.UUID  `gorm:"type:uuid;not null;index"                       json:"user_id"`
 	User      User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE,OnUpdate:CASCADE" json:"user"`
 	SessionID uuid.UUID  `gorm:"type:uuid;not null"                             json:"session_id"`
+	MeetingID *uuid.UUID `gorm:"type:uuid;index"                                json:"meeting_id,omitempty"`
 	JoinedAt  time.Time  `gorm:"not null;default:now()"                         json:"joined_at"`
 	LeftAt    *time.Time `json:"left_at,omitempty"`
 }
 
 func (*Participant) TableName() string { return "participants" }
 
+// ResumeState is what a dropped connection leaves behind in Redis so a
+// reconnect presenting its token can pick up where it left off.
+type ResumeState struct {
+	RoomID        string    `json:"roomID"`
+	UserID        string    `json:"userID"`
+	ConnID        string    `json:"connID"`
+	Role          RoomRole  `json:"role"`
+	ParticipantID uuid.UUID `json:"participantID"`
+}
+
 type Code struct {
 	ID       uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
 	UserID   uuid.UUID `gorm:"type:uuid;not null;index"                       json:"user_id"`
@@ -40,3 +176,29 @@ type Code struct {
 }
 
 func (*Code) TableName() string { return "codes" }
+
+type RoomRole string
+
+const (
+	RoleHost        RoomRole = "host"
+	RoleCoHost      RoomRole = "co-host"
+	RolePresenter   RoomRole = "presenter"
+	RoleParticipant RoomRole = "participant"
+	RoleViewer      RoomRole = "viewer"
+)
+
+var roleRanks = map[RoomRole]int{
+	RoleViewer:      0,
+	RoleParticipant: 1,
+	RolePresenter:   2,
+	RoleCoHost:      3,
+	RoleHost:        4,
+}
+
+func (r RoomRole) Valid() bool { _, ok := roleRanks[r]; return ok }
+
+func (r RoomRole) Rank() int { return roleRanks[r] }
+
+func (r RoomRole) CanModerate() bool { return r == RoleHost || r == RoleCoHost }
+
+func (r RoomRole) IsPanelist() bool { return r.Rank() >= RolePresenter.Rank() }
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ScreenSharePolicy string
+
+const (
+	ScreenShareEveryone ScreenSharePolicy = "everyone"
+	ScreenShareHosts    ScreenSharePolicy = "hosts"
+	ScreenShareDisabled ScreenSharePolicy = "disabled"
+)
+
+// DevicePolicy decides what happens when a user already in a room joins it
+// again from another tab or device.
+type DevicePolicy string
+
+const (
+	DevicesReplace  DevicePolicy = "replace"
+	DevicesMultiple DevicePolicy = "multiple"
+)
+
+const (
+	MinRoomParticipants = 2
+	MaxRoomParticipants = 1000
+)
+
+var ErrInvalidSettings = errors.New("invalid room settings")
+
+type RoomSettings struct {
+	Lobby            bool              `json:"lobby"`
+	MuteOnEntry      bool              `json:"muteOnEntry"`
+	ChatEnabled      bool              `json:"chatEnabled"`
+	ScreenShare      ScreenSharePolicy `json:"screenShare"`
+	AllowGuests      bool              `json:"allowGuests"`
+	RecordingAllowed bool              `json:"recordingAllowed"`
+	MaxParticipants  int               `json:"maxParticipants"`
+	EmailReport      bool              `json:"emailReport"`
+	DevicePolicy     DevicePolicy      `json:"devicePolicy"`
+}
+
+func DefaultRoomSettings() RoomSettings {
+	return RoomSettings{
+		ChatEnabled:     true,
+		ScreenShare:     ScreenShareEveryone,
+		MaxParticipants: 10,
+		DevicePolicy:    DevicesReplace,
+	}
+}
+
+func (s RoomSettings) Validate() error {
+	switch s.ScreenShare {
+	case ScreenShareEveryone, ScreenShareHosts, ScreenShareDisabled:
+	default:
+		return ErrInvalidSettings
+	}
+	switch s.DevicePolicy {
+	case "", DevicesReplace, DevicesMultiple:
+	default:
+		return ErrInvalidSettings
+	}
+	if s.MaxParticipants < MinRoomParticipants || s.MaxParticipants > MaxRoomParticipants {
+		return ErrInvalidSettings
+	}
+	return nil
+}
+
+func (s RoomSettings) isZero() bool { return s.ScreenShare == "" }
+
+type RoomTemplate struct {
+	ID        uuid.UUID    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	OwnerID   uuid.UUID    `gorm:"type:uuid;not null;index"                       json:"owner_id"`
+	Name      string       `gorm:"size:100;not null"                              json:"name"`
+	Settings  RoomSettings `gorm:"type:jsonb;serializer:json;not null"            json:"settings"`
+	CreatedAt time.Time    `gorm:"not null;default:now()"                         json:"created_at"`
+	UpdatedAt time.Time    `gorm:"not null;default:now()"                         json:"updated_at"`
+}
+
+func (*RoomTemplate) TableName() string { return "room_templates" }
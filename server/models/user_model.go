@@ -7,13 +7,17 @@ import (
 )
 
 type User struct {
-	ID           uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	UserName     string    `gorm:"size:100;not null"                           json:"username"`
-	Email        string    `gorm:"size:100;unique;not null"                    json:"email"`
-	ImgUrl       string    `gorm:"size:255;not null"                           json:"img_url"`
-	HashPassword string    `gorm:"size:255;not null"                           json:"hash_password"`
-	CreatedAt    time.Time `gorm:"not null;default:now()"                      json:"created_at"`
-	UpdatedAt    time.Time `gorm:"not null;default:now()"                      json:"updated_at"`
+	ID             uuid.UUID  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserName       string     `gorm:"size:100;not null"                           json:"username"`
+	Email          string     `gorm:"size:100;unique;not null"                    json:"email"`
+	ImgUrl         string     `gorm:"size:255;not null"                           json:"img_url"`
+	HashPassword   string     `gorm:"size:255;not null"                           json:"hash_password"`
+	FeedToken      string     `gorm:"size:64;index"                               json:"-"`
+	PersonalRoomID *uuid.UUID `gorm:"type:uuid"                                   json:"personal_room_id,omitempty"`
+	OrgID          *uuid.UUID `gorm:"type:uuid;index"                             json:"org_id,omitempty"`
+	Plan           string     `gorm:"size:32;not null;default:''"                 json:"plan"`
+	CreatedAt      time.Time  `gorm:"not null;default:now()"                      json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"not null;default:now()"                      json:"updated_at"`
 }
 
 func (*User) TableName() string { return "users" }
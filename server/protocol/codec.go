@@ -0,0 +1,117 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoding is how frames and room events are serialized. JSON is the
+// default; MessagePack is smaller and cheaper to handle and is negotiated
+// with a "+msgpack" subprotocol. Messages keep their JSON field names in
+// either encoding, and room events travel through Redis as MessagePack.
+type Encoding int
+
+const (
+	JSON Encoding = iota
+	MsgPack
+)
+
+func (e Encoding) String() string {
+	if e == MsgPack {
+		return "msgpack"
+	}
+	return "json"
+}
+
+// Detect tells the encoding of a message from its first byte: a MessagePack
+// message is always a map, and no JSON text starts with a map header.
+func Detect(data []byte) Encoding {
+	if len(data) > 0 && (data[0]&0xf0 == 0x80 || data[0] == 0xde || data[0] == 0xdf) {
+		return MsgPack
+	}
+	return JSON
+}
+
+func Marshal(enc Encoding, v any) ([]byte, error) {
+	if enc != MsgPack {
+		return json.Marshal(v)
+	}
+	var buf bytes.Buffer
+	e := msgpack.NewEncoder(&buf)
+	e.SetCustomStructTag("json")
+	e.UseCompactInts(true)
+	e.UseCompactFloats(true)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes a message in whichever encoding it is in.
+func Unmarshal(data []byte, v any) error {
+	if Detect(data) != MsgPack {
+		return json.Unmarshal(data, v)
+	}
+	d := msgpack.NewDecoder(bytes.NewReader(data))
+	d.SetCustomStructTag("json")
+	return d.Decode(v)
+}
+
+// Transcode re-encodes a message in enc, leaving it alone if it already is.
+func Transcode(data []byte, enc Encoding) ([]byte, error) {
+	if Detect(data) == enc {
+		return data, nil
+	}
+	var v any
+	if err := Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return Marshal(enc, v)
+}
+
+// Raw holds a JSON value the server passes along without looking inside,
+// like an SDP offer. It is kept as JSON and written as a native value in
+// MessagePack.
+type Raw []byte
+
+func (r Raw) MarshalJSON() ([]byte, error) {
+	if len(r) == 0 {
+		return []byte("null"), nil
+	}
+	return r, nil
+}
+
+func (r *Raw) UnmarshalJSON(data []byte) error {
+	if r == nil {
+		return errors.New("protocol.Raw: UnmarshalJSON on nil pointer")
+	}
+	*r = append((*r)[:0], data...)
+	return nil
+}
+
+func (r Raw) EncodeMsgpack(e *msgpack.Encoder) error {
+	if len(r) == 0 {
+		return e.EncodeNil()
+	}
+	var v any
+	if err := json.Unmarshal(r, &v); err != nil {
+		return err
+	}
+	return e.Encode(v)
+}
+
+func (r *Raw) DecodeMsgpack(d *msgpack.Decoder) error {
+	v, err := d.DecodeInterface()
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		*r = nil
+		return nil
+	}
+	*r, err = json.Marshal(v)
+	return err
+}
@@ -0,0 +1,420 @@
+package protocol
+
+import (
+	"unicode/utf8"
+
+	"video-conference/models"
+)
+
+// Client → server.
+const (
+	TypeHello              = "hello"
+	TypeHeartbeat          = "heartbeat"
+	TypeChat               = "chat-message"
+	TypeOffer              = "offer"
+	TypeAnswer             = "answer"
+	TypeICECandidate       = "ice-candidate"
+	TypeLockRoom           = "lock-room"
+	TypeEndMeeting         = "end-meeting"
+	TypeCreateBreakouts    = "create-breakouts"
+	TypeAssignBreakouts    = "assign-breakouts"
+	TypeCloseBreakouts     = "close-breakouts"
+	TypeBroadcastBreakouts = "broadcast-breakouts"
+	TypeAdmit              = "admit"
+	TypeDeny               = "deny"
+	TypeScreenShareStart   = "screen-share-start"
+	TypeScreenShareStop    = "screen-share-stop"
+	TypeRecordingStart     = "recording-start"
+	TypeRecordingStop      = "recording-stop"
+	TypeKick               = "kick"
+	TypeBan                = "ban"
+	TypeMuteRequest        = "mute-request"
+	TypeStopVideoRequest   = "stop-video-request"
+	TypeRaiseHand          = "raise-hand"
+	TypeLowerHand          = "lower-hand"
+	TypePromote            = "promote"
+	TypeDemote             = "demote"
+)
+
+var requests = map[string]bool{
+	TypeHello: true, TypeHeartbeat: true, TypeChat: true,
+	TypeOffer: true, TypeAnswer: true, TypeICECandidate: true,
+	TypeLockRoom: true, TypeEndMeeting: true,
+	TypeCreateBreakouts: true, TypeAssignBreakouts: true, TypeCloseBreakouts: true, TypeBroadcastBreakouts: true,
+	TypeAdmit: true, TypeDeny: true,
+	TypeScreenShareStart: true, TypeScreenShareStop: true, TypeRecordingStart: true, TypeRecordingStop: true,
+	TypeKick: true, TypeBan: true, TypeMuteRequest: true, TypeStopVideoRequest: true,
+	TypeRaiseHand: true, TypeLowerHand: true, TypePromote: true, TypeDemote: true,
+}
+
+// IsRequest reports whether clients may send messages of type typ.
+func IsRequest(typ string) bool { return requests[typ] }
+
+// Server → client.
+const (
+	TypeWelcome            = "welcome"
+	TypeAck                = "ack"
+	TypeHeartbeatAck       = "heartbeat-ack"
+	TypeError              = "error"
+	TypeUsersList          = "users-list"
+	TypeIceServers         = "iceServers"
+	TypeRole               = "role"
+	TypeRoomSettings       = "room-settings"
+	TypeSettingsUpdated    = "settings-updated"
+	TypeUserJoined         = "user-joined"
+	TypeUserLeft           = "user-left"
+	TypeUserReconnecting   = "user-reconnecting"
+	TypeUserReconnected    = "user-reconnected"
+	TypeDeviceJoined       = "device-joined"
+	TypeDeviceLeft         = "device-left"
+	TypeSessionReplaced    = "session-replaced"
+	TypeServerDraining     = "server-draining"
+	TypeRateLimited        = "rate-limited"
+	TypeSession            = "session"
+	TypeRoleChanged        = "role-changed"
+	TypeKicked             = "participant-kicked"
+	TypeBanned             = "participant-banned"
+	TypeMuteRequested      = "mute-requested"
+	TypeVideoStopRequested = "video-stop-requested"
+	TypeHandRaised         = "hand-raised"
+	TypeHandLowered        = "hand-lowered"
+	TypeRoomLocked         = "room-locked"
+	TypeMeetingEnded       = "meeting-ended"
+	TypeBreakoutsCreated   = "breakouts-created"
+	TypeBreakoutAssigned   = "breakout-assigned"
+	TypeBreakoutsClosing   = "breakouts-closing"
+	TypeBreakoutsClosed    = "breakouts-closed"
+	TypeBreakoutBroadcast  = "breakout-broadcast"
+	TypeLobbyWaiting       = "lobby-waiting"
+	TypeLobbyTimeout       = "lobby-timeout"
+	TypeLobbyRequest       = "lobby-request"
+	TypeLobbyAdmitted      = "lobby-admitted"
+	TypeLobbyDenied        = "lobby-denied"
+	TypeLobbyResolved      = "lobby-resolved"
+	TypeScreenShareStarted = "screen-share-started"
+	TypeScreenShareStopped = "screen-share-stopped"
+	TypeRecordingStarted   = "recording-started"
+	TypeRecordingStopped   = "recording-stopped"
+	TypeInvitation         = "invitation"
+)
+
+const MaxChatLength = 4000
+
+type Hello struct {
+	Request
+	Version int `json:"version"`
+}
+
+type Welcome struct {
+	Envelope
+	Version   int    `json:"version"`
+	Supported []int  `json:"supported"`
+	Encoding  string `json:"encoding"`
+}
+
+// HeartbeatAck answers a client heartbeat. Browsers cannot see WebSocket
+// pings, and some proxies swallow them, so clients may heartbeat in-band.
+type HeartbeatAck struct {
+	Envelope
+	Time int64 `json:"time"`
+}
+
+type Ack struct {
+	Type      string `json:"type"`
+	Action    string `json:"action"`
+	RequestID string `json:"requestId"`
+}
+
+type ChatBody struct {
+	ID   Raw    `json:"id,omitempty"`
+	Text string `json:"text"`
+	Time Raw    `json:"time,omitempty"`
+	User Raw    `json:"user,omitempty"`
+}
+
+// ChatSend accepts the message either flat or nested under "message".
+type ChatSend struct {
+	Request
+	ChatBody
+	Message *ChatBody `json:"message,omitempty"`
+}
+
+func (m *ChatSend) Body() ChatBody {
+	if m.Message != nil {
+		return *m.Message
+	}
+	return m.ChatBody
+}
+
+func (m *ChatSend) Validate() error {
+	text := m.Body().Text
+	if err := required("text", text); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(text) > MaxChatLength {
+		return Errorf(CodeBadRequest, "text longer than %d characters", MaxChatLength)
+	}
+	return nil
+}
+
+type Chat struct {
+	Envelope
+	ChatBody
+}
+
+// SignalSend goes to every connection of user To, or only to connection
+// ToConn when the target has several devices in the room.
+type SignalSend struct {
+	Request
+	To        string `json:"to"`
+	ToConn    string `json:"toConn,omitempty"`
+	Offer     Raw    `json:"offer,omitempty"`
+	Answer    Raw    `json:"answer,omitempty"`
+	Candidate Raw    `json:"candidate,omitempty"`
+}
+
+func (m *SignalSend) Validate() error {
+	if err := required("to", m.To); err != nil {
+		return err
+	}
+	var body Raw
+	switch m.Type {
+	case TypeOffer:
+		body = m.Offer
+	case TypeAnswer:
+		body = m.Answer
+	case TypeICECandidate:
+		body = m.Candidate
+	}
+	if len(body) == 0 {
+		return Errorf(CodeBadRequest, "%s payload is required", m.Type)
+	}
+	return nil
+}
+
+type Signal struct {
+	Envelope
+	RoomID    string `json:"roomID"`
+	From      string `json:"from"`
+	FromConn  string `json:"fromConn"`
+	ToConn    string `json:"toConn,omitempty"`
+	Offer     Raw    `json:"offer,omitempty"`
+	Answer    Raw    `json:"answer,omitempty"`
+	Candidate Raw    `json:"candidate,omitempty"`
+}
+
+type LockRoom struct {
+	Request
+	Locked bool `json:"locked"`
+}
+
+type RoomLocked struct {
+	Envelope
+	Locked bool `json:"locked"`
+}
+
+type CreateBreakouts struct {
+	Request
+	Count int `json:"count"`
+}
+
+func (m *CreateBreakouts) Validate() error {
+	if m.Count < 1 {
+		return Errorf(CodeBadRequest, "count must be positive")
+	}
+	return nil
+}
+
+type AssignBreakouts struct {
+	Request
+	Random      bool              `json:"random"`
+	Assignments map[string]string `json:"assignments"`
+}
+
+func (m *AssignBreakouts) Validate() error {
+	if !m.Random && len(m.Assignments) == 0 {
+		return Errorf(CodeBadRequest, "assignments or random is required")
+	}
+	return nil
+}
+
+type CloseBreakouts struct {
+	Request
+	Countdown *float64 `json:"countdown"`
+}
+
+func (m *CloseBreakouts) Validate() error {
+	if m.Countdown != nil && *m.Countdown < 0 {
+		return Errorf(CodeBadRequest, "countdown must not be negative")
+	}
+	return nil
+}
+
+type BroadcastBreakouts struct {
+	Request
+	Text string `json:"text"`
+}
+
+func (m *BroadcastBreakouts) Validate() error { return required("text", m.Text) }
+
+type BreakoutRoom struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type BreakoutsCreated struct {
+	Envelope
+	Rooms []BreakoutRoom `json:"rooms"`
+}
+
+type BreakoutAssigned struct {
+	Envelope
+	ParentID string `json:"parentID"`
+	RoomID   string `json:"roomID"`
+	Title    string `json:"title"`
+}
+
+type BreakoutsClosing struct {
+	Envelope
+	ParentID string `json:"parentID"`
+	Seconds  int    `json:"seconds"`
+}
+
+type BreakoutsClosed struct {
+	Envelope
+	ParentID string `json:"parentID"`
+}
+
+type BreakoutBroadcast struct {
+	Envelope
+	ParentID string `json:"parentID"`
+	Text     string `json:"text"`
+}
+
+type LobbyAction struct {
+	Request
+	Target string `json:"target"`
+}
+
+func (m *LobbyAction) Validate() error { return target(m.Target) }
+
+type LobbyRequest struct {
+	Envelope
+	UserInfo
+}
+
+type LobbyDecision struct {
+	Envelope
+	RoomID string `json:"roomID"`
+}
+
+type LobbyResolved struct {
+	Envelope
+	Admitted bool `json:"admitted"`
+}
+
+type FeatureEvent struct {
+	Envelope
+	UserID string `json:"userID"`
+}
+
+type Moderate struct {
+	Request
+	Target string          `json:"target"`
+	Role   models.RoomRole `json:"role"`
+}
+
+func (m *Moderate) Validate() error {
+	if m.Type == TypeRaiseHand || m.Type == TypeLowerHand {
+		return nil
+	}
+	return target(m.Target)
+}
+
+type Moderation struct {
+	Envelope
+	Role models.RoomRole `json:"role,omitempty"`
+}
+
+type UserInfo struct {
+	UserID   string          `json:"userID"`
+	UserName string          `json:"userName"`
+	ImgUrl   string          `json:"imgUrl"`
+	Role     models.RoomRole `json:"role,omitempty"`
+}
+
+// Session is sent once a connection has joined. Reconnecting with
+// ?resume=<ResumeToken>&seq=<last seen seq> within ResumeWindow seconds
+// replays the missed room events instead of joining afresh.
+type Session struct {
+	Envelope
+	ConnID       string `json:"connID"`
+	ResumeToken  string `json:"resumeToken"`
+	LastSeq      int64  `json:"lastSeq"`
+	ResumeWindow int    `json:"resumeWindow"`
+	Resumed      bool   `json:"resumed"`
+}
+
+type UsersList struct {
+	Envelope
+	Users []UserInfo `json:"users"`
+}
+
+type Presence struct {
+	Envelope
+	UserInfo
+	ConnID string `json:"connID,omitempty"`
+}
+
+// ServerDraining asks the client to move to another node: reconnect after
+// RetryAfter milliseconds, resuming with its current token, before the
+// socket is closed Deadline seconds from now.
+type ServerDraining struct {
+	Envelope
+	RetryAfter int `json:"retryAfter"`
+	Deadline   int `json:"deadline"`
+}
+
+// RateLimited warns that a message was dropped for coming too fast. Once
+// Strikes exceeds MaxStrikes the connection is closed.
+type RateLimited struct {
+	Envelope
+	Action     string `json:"action"`
+	RequestID  string `json:"requestId,omitempty"`
+	RetryAfter int    `json:"retryAfter"`
+	Strikes    int    `json:"strikes"`
+	MaxStrikes int    `json:"maxStrikes"`
+}
+
+// SessionReplaced closes every connection of the user in RoomID other than
+// ConnID, under the room's replace device policy.
+type SessionReplaced struct {
+	Envelope
+	RoomID string `json:"roomID"`
+	ConnID string `json:"connID"`
+}
+
+type IceServers struct {
+	Envelope
+	IceServers []string `json:"iceServers"`
+}
+
+type RoleAssigned struct {
+	Envelope
+	Role     models.RoomRole `json:"role"`
+	RoomType models.RoomType `json:"roomType"`
+}
+
+type Settings struct {
+	Envelope
+	Settings models.RoomSettings `json:"settings"`
+}
+
+type Invitation struct {
+	Envelope
+	ID     string `json:"id"`
+	RoomID string `json:"roomID,omitempty"`
+	Title  string `json:"title,omitempty"`
+	From   string `json:"from,omitempty"`
+	UserID string `json:"userID,omitempty"`
+}
@@ -0,0 +1,126 @@
+// Package protocol defines the messages exchanged over the room WebSocket.
+//
+// Every frame is an object with a "type", sent as JSON text or, when
+// negotiated, as binary MessagePack. Client requests may carry a
+// "requestId"; replies to them (acks and errors) echo it back. Events fanned
+// out through Redis share the routing fields in Envelope.
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// V1 is the original protocol: malformed or unknown messages are dropped
+	// without a reply and nothing is acknowledged.
+	V1 = 1
+	// V2 answers every malformed, unknown or rejected request with an error
+	// and acknowledges requests that carry a request ID.
+	V2      = 2
+	Current = V2
+)
+
+// Subprotocols are offered during the WebSocket handshake, preferred first.
+// Clients that do not negotiate one start on V1 and may upgrade with hello.
+var Subprotocols = []string{"vc.v2+msgpack", "vc.v2", "vc.v1"}
+
+func FromSubprotocol(name string) int {
+	switch strings.TrimSuffix(name, "+msgpack") {
+	case "vc.v2":
+		return V2
+	case "vc.v1":
+		return V1
+	}
+	return 0
+}
+
+func EncodingOf(subprotocol string) Encoding {
+	if strings.HasSuffix(subprotocol, "+msgpack") {
+		return MsgPack
+	}
+	return JSON
+}
+
+func Supported(v int) bool { return v >= V1 && v <= Current }
+
+// Error codes carried by error replies.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnknownType        = "unknown_type"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeForbidden          = "forbidden"
+	CodeNotAllowed         = "not_allowed"
+	CodeInvalidTarget      = "invalid_target"
+	CodeInvalidRole        = "invalid_role"
+	CodeRoomNotFound       = "room_not_found"
+	CodeRoomFull           = "room_full"
+	CodeConflict           = "conflict"
+	CodeQuotaExceeded      = "quota_exceeded"
+	CodeInternal           = "internal"
+)
+
+// Error is both the error reply sent to clients and a Go error, so handlers
+// can return it directly to pick the code.
+type Error struct {
+	Type      string `json:"type"`
+	Code      string `json:"code"`
+	Message   string `json:"error"`
+	Action    string `json:"action,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func Errorf(code, format string, args ...any) *Error {
+	return &Error{Type: TypeError, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Reply addresses e to req.
+func (e *Error) Reply(req Request) *Error {
+	out := *e
+	out.Type = TypeError
+	out.Action = req.Type
+	out.RequestID = req.RequestID
+	return &out
+}
+
+// Request is the header shared by every client message.
+type Request struct {
+	Type      string `json:"type"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+type Validator interface {
+	Validate() error
+}
+
+func (Request) Validate() error { return nil }
+
+// Envelope is the header shared by every server message. Sender marks
+// events the originating connection should not receive back; Actor is who
+// caused an event and Target whom it concerns. Room events are numbered
+// with Seq when they are published, which is what resume replays from.
+type Envelope struct {
+	Type   string `json:"type"`
+	Seq    int64  `json:"seq,omitempty"`
+	Sender string `json:"sender,omitempty"`
+	Actor  string `json:"actor,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+func Event(typ string) Envelope { return Envelope{Type: typ} }
+
+func required(field, value string) error {
+	if strings.TrimSpace(value) == "" {
+		return Errorf(CodeBadRequest, "%s is required", field)
+	}
+	return nil
+}
+
+func target(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return Errorf(CodeInvalidTarget, "target is required")
+	}
+	return nil
+}
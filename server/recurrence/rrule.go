@@ -0,0 +1,338 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// maxPeriods bounds expansion of rules that would otherwise never terminate
+// (no COUNT/UNTIL and a far-away window end).
+const maxPeriods = 5000
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	Count      int
+	Until      time.Time
+	// UntilDate marks an UNTIL given as a bare date, which includes the
+	// whole of that day in the series' time zone.
+	UntilDate bool
+}
+
+var dayCodes = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+var dayNames = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	r := &Rule{Interval: 1}
+
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, ErrInvalidRule
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.Freq = Frequency(strings.ToUpper(value))
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, ErrInvalidRule
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, ErrInvalidRule
+			}
+			r.Count = n
+		case "UNTIL":
+			t, date, err := parseUntil(value)
+			if err != nil {
+				return nil, ErrInvalidRule
+			}
+			r.Until, r.UntilDate = t, date
+		case "BYDAY":
+			for _, d := range strings.Split(strings.ToUpper(value), ",") {
+				wd, err := parseWeekdayNum(d)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, ErrInvalidRule
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "WKST":
+		default:
+			return nil, ErrInvalidRule
+		}
+	}
+
+	switch r.Freq {
+	case Daily, Weekly, Monthly:
+	default:
+		return nil, ErrInvalidRule
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, ErrInvalidRule
+	}
+	if r.Freq != Monthly && len(r.ByMonthDay) > 0 {
+		return nil, ErrInvalidRule
+	}
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != Monthly {
+			return nil, ErrInvalidRule
+		}
+	}
+	return r, nil
+}
+
+func parseUntil(v string) (t time.Time, date bool, err error) {
+	if t, err := time.Parse("20060102T150405Z", v); err == nil {
+		return t, false, nil
+	}
+	t, err = time.Parse("20060102", v)
+	return t, true, err
+}
+
+// until is the last instant the series may start at.
+func (r *Rule) until(loc *time.Location) time.Time {
+	if !r.UntilDate {
+		return r.Until
+	}
+	y, m, d := r.Until.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, loc).Add(-time.Nanosecond)
+}
+
+func parseWeekdayNum(s string) (WeekdayNum, error) {
+	if len(s) < 2 {
+		return WeekdayNum{}, ErrInvalidRule
+	}
+	day, ok := dayCodes[s[len(s)-2:]]
+	if !ok {
+		return WeekdayNum{}, ErrInvalidRule
+	}
+	n := 0
+	if prefix := s[:len(s)-2]; prefix != "" {
+		var err error
+		if n, err = strconv.Atoi(prefix); err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, ErrInvalidRule
+		}
+	}
+	return WeekdayNum{N: n, Day: day}, nil
+}
+
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = dayNames[d.Day]
+			if d.N != 0 {
+				days[i] = strconv.Itoa(d.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, d := range r.ByMonthDay {
+			days[i] = strconv.Itoa(d)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	switch {
+	case r.UntilDate:
+		parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+	case !r.Until.IsZero():
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Between returns the occurrence start times of a series beginning at start
+// that fall in [from, to). start must carry the series' time zone so that
+// wall-clock times survive DST changes.
+func (r *Rule) Between(start, from, to time.Time) []time.Time {
+	var out []time.Time
+	count := 0
+	until := r.until(start.Location())
+
+	for period := 0; period < maxPeriods; period++ {
+		candidates := r.period(start, period)
+		if len(candidates) > 0 && !candidates[0].Before(to) && candidates[0].After(start) {
+			break
+		}
+		for _, t := range candidates {
+			if t.Before(start) {
+				continue
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return out
+			}
+			if !r.Until.IsZero() && t.After(until) {
+				return out
+			}
+			if !t.Before(to) {
+				return out
+			}
+			if !t.Before(from) {
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+// Exclude drops the occurrences listed as EXDATEs.
+func Exclude(occurrences, exdates []time.Time) []time.Time {
+	if len(exdates) == 0 {
+		return occurrences
+	}
+	skip := make(map[int64]bool, len(exdates))
+	for _, ex := range exdates {
+		skip[ex.Unix()] = true
+	}
+	out := occurrences[:0:0]
+	for _, t := range occurrences {
+		if !skip[t.Unix()] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Includes reports whether t is exactly one of the series' occurrences.
+func (r *Rule) Includes(start, t time.Time) bool {
+	for _, o := range r.Between(start, t, t.Add(time.Second)) {
+		if o.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) period(start time.Time, n int) []time.Time {
+	loc := start.Location()
+	hh, mm, ss := start.Clock()
+	at := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, hh, mm, ss, 0, loc) }
+
+	var out []time.Time
+	switch r.Freq {
+	case Daily:
+		t := at(start.Year(), start.Month(), start.Day()+n*r.Interval)
+		if r.matchesWeekday(t.Weekday()) {
+			out = append(out, t)
+		}
+	case Weekly:
+		offset := (int(start.Weekday()) + 6) % 7
+		monday := at(start.Year(), start.Month(), start.Day()-offset+7*n*r.Interval)
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []WeekdayNum{{Day: start.Weekday()}}
+		}
+		for _, d := range days {
+			out = append(out, at(monday.Year(), monday.Month(), monday.Day()+(int(d.Day)+6)%7))
+		}
+	case Monthly:
+		first := time.Date(start.Year(), start.Month()+time.Month(n*r.Interval), 1, 0, 0, 0, 0, loc)
+		y, m := first.Year(), first.Month()
+		last := daysIn(y, m)
+		switch {
+		case len(r.ByMonthDay) > 0:
+			for _, d := range r.ByMonthDay {
+				if d < 0 {
+					d = last + d + 1
+				}
+				if d >= 1 && d <= last {
+					out = append(out, at(y, m, d))
+				}
+			}
+		case len(r.ByDay) > 0:
+			for _, wd := range r.ByDay {
+				for _, d := range weekdaysIn(y, m, wd, loc) {
+					out = append(out, at(y, m, d))
+				}
+			}
+		default:
+			if start.Day() <= last {
+				out = append(out, at(y, m, start.Day()))
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return out
+}
+
+func (r *Rule) matchesWeekday(d time.Weekday) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Day == d {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(y int, m time.Month) int {
+	return time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func weekdaysIn(y int, m time.Month, wd WeekdayNum, loc *time.Location) []int {
+	var days []int
+	for d := 1; d <= daysIn(y, m); d++ {
+		if time.Date(y, m, d, 12, 0, 0, 0, loc).Weekday() == wd.Day {
+			days = append(days, d)
+		}
+	}
+	switch {
+	case wd.N > 0 && wd.N <= len(days):
+		return days[wd.N-1 : wd.N]
+	case wd.N < 0 && -wd.N <= len(days):
+		return days[len(days)+wd.N : len(days)+wd.N+1]
+	case wd.N != 0:
+		return nil
+	}
+	return days
+}
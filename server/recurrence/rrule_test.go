@@ -0,0 +1,147 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBetween(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tz database:", err)
+	}
+	at := func(s string) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, ny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name    string
+		rule    string
+		start   string
+		exdates []string
+		want    []string
+	}{
+		{
+			name:  "count",
+			rule:  "FREQ=DAILY;COUNT=3",
+			start: "2025-01-06 18:00",
+			want:  []string{"2025-01-06 18:00", "2025-01-07 18:00", "2025-01-08 18:00"},
+		},
+		{
+			name:  "interval",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;COUNT=3",
+			start: "2025-01-06 18:00",
+			want:  []string{"2025-01-06 18:00", "2025-01-20 18:00", "2025-02-03 18:00"},
+		},
+		{
+			name:  "weekly byday",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=4",
+			start: "2025-01-06 18:00",
+			want:  []string{"2025-01-06 18:00", "2025-01-08 18:00", "2025-01-10 18:00", "2025-01-13 18:00"},
+		},
+		{
+			name:  "daily byday",
+			rule:  "FREQ=DAILY;BYDAY=MO,TU;COUNT=3",
+			start: "2025-01-06 18:00",
+			want:  []string{"2025-01-06 18:00", "2025-01-07 18:00", "2025-01-13 18:00"},
+		},
+		{
+			name:  "monthly last friday",
+			rule:  "FREQ=MONTHLY;BYDAY=-1FR;COUNT=2",
+			start: "2025-01-06 18:00",
+			want:  []string{"2025-01-31 18:00", "2025-02-28 18:00"},
+		},
+		{
+			name:  "until date-time is inclusive",
+			rule:  "FREQ=DAILY;UNTIL=20250108T230000Z",
+			start: "2025-01-06 18:00",
+			want:  []string{"2025-01-06 18:00", "2025-01-07 18:00", "2025-01-08 18:00"},
+		},
+		{
+			name:  "until date covers the whole local day",
+			rule:  "FREQ=DAILY;UNTIL=20250108",
+			start: "2025-01-06 18:00",
+			want:  []string{"2025-01-06 18:00", "2025-01-07 18:00", "2025-01-08 18:00"},
+		},
+		{
+			name:  "wall clock survives dst",
+			rule:  "FREQ=WEEKLY;COUNT=2",
+			start: "2025-03-03 18:00",
+			want:  []string{"2025-03-03 18:00", "2025-03-10 18:00"},
+		},
+		{
+			name:    "exdate",
+			rule:    "FREQ=DAILY;COUNT=5",
+			start:   "2025-01-06 18:00",
+			exdates: []string{"2025-01-07 18:00", "2025-01-09 18:00"},
+			want:    []string{"2025-01-06 18:00", "2025-01-08 18:00", "2025-01-10 18:00"},
+		},
+		{
+			name:    "exdate off the series changes nothing",
+			rule:    "FREQ=WEEKLY;COUNT=2",
+			start:   "2025-01-06 18:00",
+			exdates: []string{"2025-01-07 18:00"},
+			want:    []string{"2025-01-06 18:00", "2025-01-13 18:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.rule, err)
+			}
+			start := at(tt.start)
+			var exdates []time.Time
+			for _, ex := range tt.exdates {
+				exdates = append(exdates, at(ex))
+			}
+
+			got := Exclude(r.Between(start, start, start.AddDate(1, 0, 0)), exdates)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d occurrences %v, want %v", len(got), got, tt.want)
+			}
+			for i, w := range tt.want {
+				if !got[i].Equal(at(w)) {
+					t.Errorf("occurrence %d = %s, want %s", i, got[i].In(ny).Format("2006-01-02 15:04"), w)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, rule := range []string{
+		"FREQ=YEARLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=2;UNTIL=20250101",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=DAILY;UNTIL=2025-01-01",
+	} {
+		if _, err := Parse(rule); err == nil {
+			t.Errorf("Parse(%q) succeeded", rule)
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, rule := range []string{
+		"FREQ=DAILY;COUNT=3",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE",
+		"FREQ=MONTHLY;BYDAY=-1FR;UNTIL=20250630T000000Z",
+		"FREQ=DAILY;UNTIL=20250108",
+	} {
+		r, err := Parse(rule)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", rule, err)
+		}
+		if got := r.String(); got != rule {
+			t.Errorf("String() = %q, want %q", got, rule)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package repositories
+
+import (
+	"context"
+
+	"video-conference/models"
+
+	"gorm.io/gorm"
+)
+
+type AuditRepository struct{ db *gorm.DB }
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository { return &AuditRepository{db: db} }
+
+func (r *AuditRepository) Record(ctx context.Context, rec *models.AuditRecord) error {
+	return r.db.WithContext(ctx).Create(rec).Error
+}
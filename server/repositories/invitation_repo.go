@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"video-conference/models"
+
+	"gorm.io/gorm"
+)
+
+type InvitationRepository struct{ db *gorm.DB }
+
+func NewInvitationRepository(db *gorm.DB) *InvitationRepository {
+	return &InvitationRepository{db: db}
+}
+
+func (r *InvitationRepository) CreateInvitation(ctx context.Context, inv *models.Invitation) error {
+	return r.db.WithContext(ctx).Create(inv).Error
+}
+
+func (r *InvitationRepository) GetInvitation(ctx context.Context, id string) (*models.Invitation, error) {
+	var inv models.Invitation
+	err := r.db.WithContext(ctx).
+		Preload("Room").
+		First(&inv, "id = ?", id).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &inv, err
+}
+
+func (r *InvitationRepository) ListPendingForUser(ctx context.Context, userID, email string) ([]models.Invitation, error) {
+	var out []models.Invitation
+	err := r.db.WithContext(ctx).
+		Preload("Room").
+		Where("status = ?", models.InvitationPending).
+		Where("invitee_id = ? OR lower(email) = lower(?)", userID, email).
+		Order("created_at DESC").
+		Find(&out).Error
+	return out, err
+}
+
+func (r *InvitationRepository) SetStatus(ctx context.Context, id string, status models.InvitationStatus) error {
+	return r.db.WithContext(ctx).
+		Model(&models.Invitation{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"status": status, "responded_at": time.Now()}).Error
+}
+
+func (r *InvitationRepository) IsInvited(ctx context.Context, roomID, userID, email string) (bool, error) {
+	var n int64
+	err := r.db.WithContext(ctx).
+		Model(&models.Invitation{}).
+		Where("room_id = ? AND status <> ?", roomID, models.InvitationDeclined).
+		Where("invitee_id = ? OR lower(email) = lower(?)", userID, email).
+		Count(&n).Error
+	return n > 0, err
+}
@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"video-conference/models"
+
+	"gorm.io/gorm"
+)
+
+type MeetingRepository struct{ db *gorm.DB }
+
+func NewMeetingRepository(db *gorm.DB) *MeetingRepository { return &MeetingRepository{db: db} }
+
+func (r *MeetingRepository) CreateMeeting(ctx context.Context, m *models.MeetingSession) error {
+	return r.db.WithContext(ctx).Omit("Room").Create(m).Error
+}
+
+func (r *MeetingRepository) SaveMeeting(ctx context.Context, m *models.MeetingSession) error {
+	return r.db.WithContext(ctx).Omit("Room").Save(m).Error
+}
+
+func (r *MeetingRepository) GetMeeting(ctx context.Context, id string) (*models.MeetingSession, error) {
+	var m models.MeetingSession
+	err := r.db.WithContext(ctx).First(&m, "id = ?", id).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &m, err
+}
+
+func (r *MeetingRepository) ListMeetings(ctx context.Context, roomID string, limit int) ([]models.MeetingSession, error) {
+	var out []models.MeetingSession
+	err := r.db.WithContext(ctx).
+		Where("room_id = ?", roomID).
+		Order("started_at DESC").
+		Limit(limit).
+		Find(&out).Error
+	return out, err
+}
+
+func (r *MeetingRepository) ListParticipations(ctx context.Context, meetingID string) ([]models.Participant, error) {
+	var out []models.Participant
+	err := r.db.WithContext(ctx).
+		Preload("User").
+		Where("meeting_id = ?", meetingID).
+		Order("joined_at").
+		Find(&out).Error
+	return out, err
+}
+
+func (r *MeetingRepository) CloseParticipations(ctx context.Context, meetingID string, at time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&models.Participant{}).
+		Where("meeting_id = ? AND left_at IS NULL", meetingID).
+		Update("left_at", at).Error
+}
@@ -0,0 +1,117 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"video-conference/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OrgRepository struct{ db *gorm.DB }
+
+func NewOrgRepository(db *gorm.DB) *OrgRepository { return &OrgRepository{db: db} }
+
+// CreateOrg stores the organization with owner as its first member.
+func (r *OrgRepository) CreateOrg(ctx context.Context, org *models.Organization, owner uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Omit(clause.Associations).Create(&models.OrgMembership{
+			OrgID:     org.ID,
+			UserID:    owner,
+			Role:      models.OrgOwner,
+			CreatedAt: time.Now(),
+		}).Error
+	})
+}
+
+func (r *OrgRepository) UpdateOrg(ctx context.Context, org *models.Organization) error {
+	org.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Save(org).Error
+}
+
+func (r *OrgRepository) GetOrg(ctx context.Context, id string) (*models.Organization, error) {
+	var org models.Organization
+	err := r.db.WithContext(ctx).First(&org, "id = ?", id).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &org, err
+}
+
+func (r *OrgRepository) ListForUser(ctx context.Context, userID string) ([]models.OrgMembership, error) {
+	var out []models.OrgMembership
+	err := r.db.WithContext(ctx).
+		Preload("Org").
+		Where("user_id = ?", userID).
+		Order("created_at").
+		Find(&out).Error
+	return out, err
+}
+
+func (r *OrgRepository) GetMembership(ctx context.Context, orgID, userID string) (*models.OrgMembership, error) {
+	var m models.OrgMembership
+	err := r.db.WithContext(ctx).First(&m, "org_id = ? AND user_id = ?", orgID, userID).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &m, err
+}
+
+func (r *OrgRepository) ListMembers(ctx context.Context, orgID string) ([]models.OrgMembership, error) {
+	var out []models.OrgMembership
+	err := r.db.WithContext(ctx).
+		Preload("User").
+		Where("org_id = ?", orgID).
+		Order("created_at").
+		Find(&out).Error
+	return out, err
+}
+
+// SetMember adds the user to the organization or changes their role.
+func (r *OrgRepository) SetMember(ctx context.Context, orgID, userID uuid.UUID, role models.OrgRole) error {
+	return r.db.WithContext(ctx).
+		Omit(clause.Associations).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "org_id"}, {Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"role"}),
+		}).
+		Create(&models.OrgMembership{OrgID: orgID, UserID: userID, Role: role, CreatedAt: time.Now()}).Error
+}
+
+// RemoveMember also clears the user's home organization if it was this one.
+func (r *OrgRepository) RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("org_id = ? AND user_id = ?", orgID, userID).
+			Delete(&models.OrgMembership{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).
+			Where("id = ? AND org_id = ?", userID, orgID).
+			Update("org_id", nil).Error
+	})
+}
+
+func (r *OrgRepository) CountOwners(ctx context.Context, orgID string) (int64, error) {
+	var n int64
+	err := r.db.WithContext(ctx).
+		Model(&models.OrgMembership{}).
+		Where("org_id = ? AND role = ?", orgID, models.OrgOwner).
+		Count(&n).Error
+	return n, err
+}
+
+func (r *OrgRepository) SetHomeOrg(ctx context.Context, userID string, orgID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("org_id", orgID).Error
+}
@@ -3,12 +3,18 @@ package repositories
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"video-conference/models"
+	"video-conference/protocol"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type RoomRepository struct {
@@ -27,36 +33,462 @@ func NewRoomRepository(rdb *redis.Client, db *gorm.DB) *RoomRepository {
 
 func participantsKey(roomID string) string { return "room:" + roomID + ":participants" }
 func channelKey(roomID string) string      { return "room:" + roomID }
+func userChannelKey(userID string) string  { return "user:" + userID }
+func bannedKey(roomID string) string       { return "room:" + roomID + ":banned" }
+func lockedKey(roomID string) string       { return "room:" + roomID + ":locked" }
+func seenKey(roomID string) string         { return "room:" + roomID + ":seen" }
+func admittedKey(roomID string) string     { return "room:" + roomID + ":admitted" }
+func meetingKey(roomID string) string      { return "room:" + roomID + ":meeting" }
+func meetingStatsKey(roomID string) string { return "room:" + roomID + ":meeting:stats" }
+func seqKey(roomID string) string          { return "room:" + roomID + ":seq" }
+func rolesKey(roomID string) string        { return "room:" + roomID + ":roles" }
+func eventLogKey(roomID string) string     { return "room:" + roomID + ":log" }
+func resumeKey(token string) string        { return "resume:" + token }
+func nodeAliveKey(nodeID string) string    { return "node:" + nodeID + ":alive" }
+func nodePresenceKey(nodeID string) string { return "node:" + nodeID + ":presence" }
+
+const nodesKey = "nodes"
+
+// PresenceEntry is one user's socket in one room, as tracked by the node
+// holding it.
+type PresenceEntry struct {
+	RoomID string
+	UserID string
+}
+
+func (e PresenceEntry) member() string { return e.RoomID + "/" + e.UserID }
+
+func parsePresenceEntry(member string) (PresenceEntry, bool) {
+	roomID, userID, ok := strings.Cut(member, "/")
+	return PresenceEntry{RoomID: roomID, UserID: userID}, ok
+}
+
+// eventBacklog is how many room events are kept for replay on resume.
+const eventBacklog = 500
+
+// publishScript numbers a room event, appends it to the replay log and
+// publishes it in one step, so sequence order is delivery order.
+// The number goes in as the first field of the event, which is a JSON
+// object or a MessagePack map. The MessagePack field is encoded by hand so
+// the script does not depend on the cmsgpack library.
+var publishScript = redis.NewScript(`
+local function uint(prefix, n, bytes)
+  local out = {}
+  for i = bytes, 1, -1 do
+    out[i] = string.char(n % 256)
+    n = math.floor(n / 256)
+  end
+  return string.char(prefix) .. table.concat(out)
+end
+local function seqfield(n)
+  local v
+  if n < 128 then v = string.char(n)
+  elseif n < 65536 then v = uint(0xcd, n, 2)
+  elseif n < 4294967296 then v = uint(0xce, n, 4)
+  else v = uint(0xcf, n, 8) end
+  return '\163seq' .. v
+end
+
+local seq = redis.call('INCR', KEYS[1])
+local body = ARGV[1]
+local head = string.byte(body, 1)
+local msg
+if head == 123 then
+  msg = '{"seq":' .. seq .. ',' .. string.sub(body, 2)
+elseif head >= 0x80 and head < 0x8f then
+  msg = string.char(head + 1) .. seqfield(seq) .. string.sub(body, 2)
+elseif head == 0x8f then
+  msg = string.char(0xde, 0, 16) .. seqfield(seq) .. string.sub(body, 2)
+elseif head == 0xde then
+  local n = string.byte(body, 2) * 256 + string.byte(body, 3) + 1
+  msg = string.char(0xde, math.floor(n / 256), n % 256) .. seqfield(seq) .. string.sub(body, 4)
+else
+  return redis.error_reply('room event must be an object')
+end
+redis.call('RPUSH', KEYS[2], msg)
+redis.call('LTRIM', KEYS[2], -tonumber(ARGV[2]), -1)
+redis.call('PUBLISH', KEYS[3], msg)
+return seq
+`)
 
 func (r *RoomRepository) AddParticipant(ctx context.Context, roomID, userID string) error {
-	return r.redis.SAdd(ctx, participantsKey(roomID), userID).Err()
+	_, err := r.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.SAdd(ctx, participantsKey(roomID), userID)
+		p.ZAdd(ctx, seenKey(roomID), &redis.Z{Score: float64(time.Now().Unix()), Member: userID})
+		return nil
+	})
+	return err
 }
 
 func (r *RoomRepository) RemoveParticipant(ctx context.Context, roomID, userID string) error {
-	return r.redis.SRem(ctx, participantsKey(roomID), userID).Err()
+	_, err := r.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.SRem(ctx, participantsKey(roomID), userID)
+		p.ZRem(ctx, seenKey(roomID), userID)
+		p.HDel(ctx, rolesKey(roomID), userID)
+		return nil
+	})
+	return err
+}
+
+func (r *RoomRepository) CountParticipants(ctx context.Context, roomID string) (int64, error) {
+	return r.redis.SCard(ctx, participantsKey(roomID)).Result()
+}
+
+// TouchParticipants records that userIDs still hold a live socket in roomID.
+func (r *RoomRepository) TouchParticipants(ctx context.Context, roomID string, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	now := float64(time.Now().Unix())
+	members := make([]*redis.Z, len(userIDs))
+	for i, id := range userIDs {
+		members[i] = &redis.Z{Score: now, Member: id}
+	}
+	return r.redis.ZAdd(ctx, seenKey(roomID), members...).Err()
+}
+
+// PurgeStaleParticipants drops participants not touched since before, as
+// well as set members that were never touched at all.
+func (r *RoomRepository) PurgeStaleParticipants(ctx context.Context, roomID string, before time.Time) ([]string, error) {
+	stale, err := r.redis.ZRangeByScore(ctx, seenKey(roomID), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", before.Unix()),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := r.redis.SMembers(ctx, participantsKey(roomID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range members {
+		if err := r.redis.ZScore(ctx, seenKey(roomID), id).Err(); errors.Is(err, redis.Nil) {
+			stale = append(stale, id)
+		}
+	}
+	if len(stale) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]interface{}, len(stale))
+	for i, id := range stale {
+		ids[i] = id
+	}
+	_, err = r.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.SRem(ctx, participantsKey(roomID), ids...)
+		p.ZRem(ctx, seenKey(roomID), ids...)
+		return nil
+	})
+	return stale, err
+}
+
+// BeatNode marks nodeID alive for ttl.
+func (r *RoomRepository) BeatNode(ctx context.Context, nodeID string, ttl time.Duration) error {
+	_, err := r.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.SAdd(ctx, nodesKey, nodeID)
+		p.Set(ctx, nodeAliveKey(nodeID), time.Now().Unix(), ttl)
+		return nil
+	})
+	return err
+}
+
+// DeadNodes lists known nodes whose heartbeat has lapsed.
+func (r *RoomRepository) DeadNodes(ctx context.Context) ([]string, error) {
+	nodes, err := r.redis.SMembers(ctx, nodesKey).Result()
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	exists := make([]*redis.IntCmd, len(nodes))
+	_, err = r.redis.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, n := range nodes {
+			exists[i] = p.Exists(ctx, nodeAliveKey(n))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var dead []string
+	for i, n := range nodes {
+		if exists[i].Val() == 0 {
+			dead = append(dead, n)
+		}
+	}
+	return dead, nil
+}
+
+func (r *RoomRepository) TrackPresence(ctx context.Context, nodeID, roomID, userID string) error {
+	return r.redis.SAdd(ctx, nodePresenceKey(nodeID), PresenceEntry{roomID, userID}.member()).Err()
+}
+
+func (r *RoomRepository) UntrackPresence(ctx context.Context, nodeID, roomID, userID string) error {
+	return r.redis.SRem(ctx, nodePresenceKey(nodeID), PresenceEntry{roomID, userID}.member()).Err()
+}
+
+// TakeNodePresence removes nodeID and hands its presence entries to exactly
+// one caller.
+func (r *RoomRepository) TakeNodePresence(ctx context.Context, nodeID string) ([]PresenceEntry, error) {
+	var members *redis.StringSliceCmd
+	_, err := r.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		members = p.SMembers(ctx, nodePresenceKey(nodeID))
+		p.Del(ctx, nodePresenceKey(nodeID), nodeAliveKey(nodeID))
+		p.SRem(ctx, nodesKey, nodeID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]PresenceEntry, 0, len(members.Val()))
+	for _, m := range members.Val() {
+		if e, ok := parsePresenceEntry(m); ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// IsTracked reports whether any node still holds a socket for the entry.
+func (r *RoomRepository) IsTracked(ctx context.Context, e PresenceEntry) (bool, error) {
+	nodes, err := r.redis.SMembers(ctx, nodesKey).Result()
+	if err != nil {
+		return false, err
+	}
+	for _, n := range nodes {
+		if ok, err := r.redis.SIsMember(ctx, nodePresenceKey(n), e.member()).Result(); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// PresentUsers lists the users holding a socket in roomID on any node.
+func (r *RoomRepository) PresentUsers(ctx context.Context, roomID string) ([]string, error) {
+	nodes, err := r.redis.SMembers(ctx, nodesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var users []string
+	for _, n := range nodes {
+		iter := r.redis.SScan(ctx, nodePresenceKey(n), 0, roomID+"/*", 0).Iterator()
+		for iter.Next(ctx) {
+			if e, ok := parsePresenceEntry(iter.Val()); ok && !seen[e.UserID] {
+				seen[e.UserID] = true
+				users = append(users, e.UserID)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// SetRole records userID's role in roomID for every node to see.
+func (r *RoomRepository) SetRole(ctx context.Context, roomID, userID string, role models.RoomRole) error {
+	return r.redis.HSet(ctx, rolesKey(roomID), userID, string(role)).Err()
+}
+
+func (r *RoomRepository) Roles(ctx context.Context, roomID string) (map[string]models.RoomRole, error) {
+	raw, err := r.redis.HGetAll(ctx, rolesKey(roomID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[string]models.RoomRole, len(raw))
+	for id, role := range raw {
+		roles[id] = models.RoomRole(role)
+	}
+	return roles, nil
+}
+
+// RoomIDsWithState lists rooms that currently have a participant set in Redis.
+func (r *RoomRepository) RoomIDsWithState(ctx context.Context) ([]string, error) {
+	var ids []string
+	iter := r.redis.Scan(ctx, 0, participantsKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		ids = append(ids, key[len("room:"):len(key)-len(":participants")])
+	}
+	return ids, iter.Err()
+}
+
+// AcquireLock lets a single node run a periodic job; the lock simply expires.
+func (r *RoomRepository) AcquireLock(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	return r.redis.SetNX(ctx, "lock:"+name, 1, ttl).Result()
 }
 
 func (r *RoomRepository) GetParticipants(ctx context.Context, roomID string) ([]string, error) {
 	return r.redis.SMembers(ctx, participantsKey(roomID)).Result()
 }
 
+func (r *RoomRepository) IsParticipant(ctx context.Context, roomID, userID string) (bool, error) {
+	return r.redis.SIsMember(ctx, participantsKey(roomID), userID).Result()
+}
+
+func (r *RoomRepository) BanUser(ctx context.Context, roomID, userID string) error {
+	return r.redis.SAdd(ctx, bannedKey(roomID), userID).Err()
+}
+
+func (r *RoomRepository) IsBanned(ctx context.Context, roomID, userID string) (bool, error) {
+	return r.redis.SIsMember(ctx, bannedKey(roomID), userID).Result()
+}
+
+func (r *RoomRepository) Admit(ctx context.Context, roomID, userID string) error {
+	return r.redis.SAdd(ctx, admittedKey(roomID), userID).Err()
+}
+
+func (r *RoomRepository) IsAdmitted(ctx context.Context, roomID, userID string) (bool, error) {
+	return r.redis.SIsMember(ctx, admittedKey(roomID), userID).Result()
+}
+
+func (r *RoomRepository) SetLocked(ctx context.Context, roomID string, locked bool) error {
+	if !locked {
+		return r.redis.Del(ctx, lockedKey(roomID)).Err()
+	}
+	return r.redis.Set(ctx, lockedKey(roomID), 1, 0).Err()
+}
+
+func (r *RoomRepository) IsLocked(ctx context.Context, roomID string) (bool, error) {
+	n, err := r.redis.Exists(ctx, lockedKey(roomID)).Result()
+	return n > 0, err
+}
+
+func (r *RoomRepository) ClearRoomState(ctx context.Context, roomID string) error {
+	return r.redis.Del(ctx,
+		participantsKey(roomID), seenKey(roomID), bannedKey(roomID), lockedKey(roomID), admittedKey(roomID),
+		meetingKey(roomID), meetingStatsKey(roomID), seqKey(roomID), eventLogKey(roomID), rolesKey(roomID),
+	).Err()
+}
+
+func (r *RoomRepository) CurrentMeeting(ctx context.Context, roomID string) (string, error) {
+	id, err := r.redis.Get(ctx, meetingKey(roomID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return id, err
+}
+
+// StartMeeting claims the room for meetingID unless another meeting is
+// already running in it.
+func (r *RoomRepository) StartMeeting(ctx context.Context, roomID, meetingID string) (bool, error) {
+	return r.redis.SetNX(ctx, meetingKey(roomID), meetingID, 0).Result()
+}
+
+// TakeMeeting atomically removes the running meeting and its counters so that
+// exactly one caller gets to finalize it.
+func (r *RoomRepository) TakeMeeting(ctx context.Context, roomID string) (string, map[string]string, error) {
+	var get *redis.StringCmd
+	var stats *redis.StringStringMapCmd
+	_, err := r.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.Get(ctx, meetingKey(roomID))
+		stats = p.HGetAll(ctx, meetingStatsKey(roomID))
+		p.Del(ctx, meetingKey(roomID), meetingStatsKey(roomID))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", nil, err
+	}
+	return get.Val(), stats.Val(), nil
+}
+
+func (r *RoomRepository) CountMeetingEvent(ctx context.Context, roomID, field string) error {
+	return r.redis.HIncrBy(ctx, meetingStatsKey(roomID), field, 1).Err()
+}
+
 func (r *RoomRepository) PublishMessage(ctx context.Context, roomID string, message interface{}) error {
-	payload, err := json.Marshal(message)
+	payload, err := protocol.Marshal(protocol.MsgPack, message)
 	if err != nil {
 		return fmt.Errorf("marshal: %w", err)
 	}
-	return r.redis.Publish(ctx, channelKey(roomID), payload).Err()
+	keys := []string{seqKey(roomID), eventLogKey(roomID), channelKey(roomID)}
+	return publishScript.Run(ctx, r.redis, keys, payload, eventBacklog).Err()
+}
+
+func (r *RoomRepository) RoomSeq(ctx context.Context, roomID string) (int64, error) {
+	seq, err := r.redis.Get(ctx, seqKey(roomID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return seq, err
+}
+
+// EventsSince returns the logged room events numbered after seq. complete
+// is false when some of them have already been trimmed from the log.
+func (r *RoomRepository) EventsSince(ctx context.Context, roomID string, seq int64) (events []string, complete bool, err error) {
+	all, err := r.redis.LRange(ctx, eventLogKey(roomID), 0, -1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	for i, raw := range all {
+		var head struct {
+			Seq int64 `json:"seq"`
+		}
+		if protocol.Unmarshal([]byte(raw), &head) != nil || head.Seq <= seq {
+			continue
+		}
+		return all[i:], head.Seq == seq+1, nil
+	}
+	return nil, true, nil
+}
+
+func (r *RoomRepository) HoldResume(ctx context.Context, token string, state *models.ResumeState, ttl time.Duration) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return r.redis.Set(ctx, resumeKey(token), payload, ttl).Err()
+}
+
+// ClaimResume hands the state held under token to exactly one caller.
+func (r *RoomRepository) ClaimResume(ctx context.Context, token string) (*models.ResumeState, error) {
+	var get *redis.StringCmd
+	_, err := r.redis.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		get = p.Get(ctx, resumeKey(token))
+		p.Del(ctx, resumeKey(token))
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state models.ResumeState
+	if err := json.Unmarshal([]byte(get.Val()), &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func (r *RoomRepository) PublishToUser(ctx context.Context, userID string, message interface{}) (delivered int64, err error) {
+	payload, err := protocol.Marshal(protocol.MsgPack, message)
+	if err != nil {
+		return 0, fmt.Errorf("marshal: %w", err)
+	}
+	return r.redis.Publish(ctx, userChannelKey(userID), payload).Result()
 }
 
 func (r *RoomRepository) SubscribeToRoom(
 	ctx context.Context,
 	roomID string,
+	userID string,
 ) (*RoomSubscription, error) {
-	ps := r.redis.Subscribe(ctx, channelKey(roomID))
+	return r.subscribe(ctx, channelKey(roomID), userChannelKey(userID))
+}
 
-	if _, err := ps.Receive(ctx); err != nil {
-		_ = ps.Close()
-		return nil, fmt.Errorf("subscribe: %w", err)
+func (r *RoomRepository) SubscribeToUser(ctx context.Context, userID string) (*RoomSubscription, error) {
+	return r.subscribe(ctx, userChannelKey(userID))
+}
+
+func (r *RoomRepository) subscribe(ctx context.Context, channels ...string) (*RoomSubscription, error) {
+	ps := r.redis.Subscribe(ctx, channels...)
+
+	for range channels {
+		if _, err := ps.Receive(ctx); err != nil {
+			_ = ps.Close()
+			return nil, fmt.Errorf("subscribe: %w", err)
+		}
 	}
 
 	return &RoomSubscription{
@@ -74,12 +506,331 @@ func (r *RoomRepository) UnsubscribeFromRoom(_ context.Context, sub *RoomSubscri
 
 func (r *RoomRepository) GetRoom(ctx context.Context, roomID string) (*models.Room, error) {
 	var room models.Room
-	if err := r.db.WithContext(ctx).First(&room, "id = ?", roomID).Error; err != nil {
+	if err := r.withCoOwners(ctx).First(&room, "id = ?", roomID).Error; err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+func (r *RoomRepository) GetRoomBySlug(ctx context.Context, slug string) (*models.Room, error) {
+	var room models.Room
+	if err := r.withCoOwners(ctx).First(&room, "slug = ?", slug).Error; err != nil {
 		return nil, err
 	}
 	return &room, nil
 }
 
+func (r *RoomRepository) withCoOwners(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).Preload("CoOwners", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	})
+}
+
+func (r *RoomRepository) AddCoOwner(ctx context.Context, roomID, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.RoomCoOwner{RoomID: roomID, UserID: userID, CreatedAt: time.Now()}).Error
+}
+
+func (r *RoomRepository) RemoveCoOwner(ctx context.Context, roomID, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Where("room_id = ? AND user_id = ?", roomID, userID).
+		Delete(&models.RoomCoOwner{}).Error
+}
+
+// TransferOwnership hands the room and its breakout rooms to newOwner. When
+// keepPrevious is set the old owner stays on as a co-owner.
+func (r *RoomRepository) TransferOwnership(ctx context.Context, room *models.Room, newOwner uuid.UUID, keepPrevious bool) error {
+	prev := room.OwnerID
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Room{}).
+			Where("id = ? OR parent_id = ?", room.ID, room.ID).
+			Updates(map[string]any{"owner_id": newOwner, "updated_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("room_id = ? AND user_id = ?", room.ID, newOwner).
+			Delete(&models.RoomCoOwner{}).Error; err != nil {
+			return err
+		}
+		if !keepPrevious {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.RoomCoOwner{RoomID: room.ID, UserID: prev, CreatedAt: time.Now()}).Error
+	})
+}
+
+func (r *RoomRepository) GetSlugRedirect(ctx context.Context, slug string) (*models.RoomSlugRedirect, error) {
+	var red models.RoomSlugRedirect
+	if err := r.db.WithContext(ctx).First(&red, "slug = ?", slug).Error; err != nil {
+		return nil, err
+	}
+	return &red, nil
+}
+
+// SlugTaken reports whether slug is in use, or reserved as a redirect, by a
+// room other than roomID.
+func (r *RoomRepository) SlugTaken(ctx context.Context, slug string, roomID uuid.UUID) (bool, error) {
+	var n int64
+	err := r.db.WithContext(ctx).Model(&models.Room{}).
+		Where("slug = ? AND id <> ?", slug, roomID).
+		Count(&n).Error
+	if err != nil || n > 0 {
+		return n > 0, err
+	}
+	err = r.db.WithContext(ctx).Model(&models.RoomSlugRedirect{}).
+		Where("slug = ? AND room_id <> ?", slug, roomID).
+		Count(&n).Error
+	return n > 0, err
+}
+
+func (r *RoomRepository) SetSlug(ctx context.Context, room *models.Room, slug string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if room.Slug != nil && *room.Slug != slug {
+			red := models.RoomSlugRedirect{Slug: *room.Slug, RoomID: room.ID}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&red).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&models.RoomSlugRedirect{}, "slug = ?", slug).Error; err != nil {
+			return err
+		}
+		room.Slug = &slug
+		return tx.Model(room).Updates(map[string]any{"slug": slug, "updated_at": time.Now()}).Error
+	})
+}
+
+func (r *RoomRepository) ListChildRooms(ctx context.Context, parentID string) ([]models.Room, error) {
+	var rooms []models.Room
+	err := r.db.WithContext(ctx).
+		Where("parent_id = ? AND is_active", parentID).
+		Order("created_at, title").
+		Find(&rooms).Error
+	return rooms, err
+}
+
 func (r *RoomRepository) CreateRoom(ctx context.Context, room *models.Room) error {
 	return r.db.WithContext(ctx).Create(room).Error
 }
+
+func (r *RoomRepository) DeleteRoom(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&models.Room{}, "id = ?", id).Error
+}
+
+func (r *RoomRepository) ListActiveRooms(ctx context.Context) ([]models.Room, error) {
+	var rooms []models.Room
+	err := r.db.WithContext(ctx).Where("is_active").Find(&rooms).Error
+	return rooms, err
+}
+
+type RoomSearch struct {
+	UserID string
+	Query  string
+	Tags   []string
+	Limit  int
+	Offset int
+}
+
+type RoomHit struct {
+	models.Room
+	Rank float64 `json:"rank"`
+}
+
+// SearchRooms ranks top-level rooms the user owns, co-owns, has joined or that
+// are discoverable, limited to the user's organizations and shared rooms.
+// Without a query the most recently updated rooms come first.
+func (r *RoomRepository) SearchRooms(ctx context.Context, q RoomSearch) ([]RoomHit, error) {
+	tx := r.db.WithContext(ctx).
+		Model(&models.Room{}).
+		Where("is_active AND parent_id IS NULL").
+		Where("discoverable OR owner_id = ? OR id IN (?) OR id IN (?)", q.UserID,
+			r.db.Model(&models.RoomCoOwner{}).Select("room_id").Where("user_id = ?", q.UserID),
+			r.db.Model(&models.Participant{}).Select("room_id").Where("user_id = ?", q.UserID)).
+		Where("org_id IS NULL OR shared OR owner_id = ? OR org_id IN (?)", q.UserID,
+			r.db.Model(&models.OrgMembership{}).Select("org_id").Where("user_id = ?", q.UserID))
+
+	if len(q.Tags) > 0 {
+		tags, _ := json.Marshal(q.Tags)
+		tx = tx.Where("tags @> ?::jsonb", string(tags))
+	}
+	if q.Query != "" {
+		tx = tx.Select("rooms.*, ts_rank("+models.RoomSearchVector+", websearch_to_tsquery('english', ?)) AS rank", q.Query).
+			Where(models.RoomSearchVector+" @@ websearch_to_tsquery('english', ?)", q.Query).
+			Order("rank DESC")
+	} else {
+		tx = tx.Select("rooms.*, 0 AS rank")
+	}
+
+	var hits []RoomHit
+	err := tx.Order("updated_at DESC").Limit(q.Limit).Offset(q.Offset).Find(&hits).Error
+	return hits, err
+}
+
+func (r *RoomRepository) SetTags(ctx context.Context, roomID uuid.UUID, tags []string) error {
+	raw, _ := json.Marshal(tags)
+	return r.db.WithContext(ctx).
+		Model(&models.Room{}).
+		Where("id = ?", roomID).
+		Updates(map[string]any{"tags": gorm.Expr("?::jsonb", string(raw)), "updated_at": time.Now()}).Error
+}
+
+// RecordJoin stores a participation row and returns its ID for RecordLeave.
+func (r *RoomRepository) RecordJoin(ctx context.Context, roomID, userID, sessionID uuid.UUID, meetingID *uuid.UUID) (uuid.UUID, error) {
+	p := models.Participant{
+		ID:        uuid.New(),
+		RoomID:    roomID,
+		UserID:    userID,
+		SessionID: sessionID,
+		MeetingID: meetingID,
+		JoinedAt:  time.Now(),
+	}
+	return p.ID, r.db.WithContext(ctx).Omit("User").Create(&p).Error
+}
+
+// OpenParticipation returns the participation of userID in roomID that has
+// not ended yet, or uuid.Nil.
+func (r *RoomRepository) OpenParticipation(ctx context.Context, roomID, userID uuid.UUID) (uuid.UUID, error) {
+	var p models.Participant
+	err := r.db.WithContext(ctx).
+		Where("room_id = ? AND user_id = ? AND left_at IS NULL", roomID, userID).
+		Order("joined_at DESC").
+		First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, nil
+	}
+	return p.ID, err
+}
+
+func (r *RoomRepository) RecordLeave(ctx context.Context, participantID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Model(&models.Participant{}).
+		Where("id = ? AND left_at IS NULL", participantID).
+		Update("left_at", time.Now()).Error
+}
+
+func (r *RoomRepository) SetEmptySince(ctx context.Context, roomID string, since *time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&models.Room{}).
+		Where("id = ?", roomID).
+		Update("empty_since", since).Error
+}
+
+func (r *RoomRepository) DeactivateRoom(ctx context.Context, roomID string) error {
+	return r.db.WithContext(ctx).
+		Model(&models.Room{}).
+		Where("id = ? AND NOT permanent", roomID).
+		Updates(map[string]any{"is_active": false, "updated_at": time.Now()}).Error
+}
+
+func (r *RoomRepository) UpdateRoom(ctx context.Context, room *models.Room) error {
+	room.UpdatedAt = time.Now()
+	return r.db.WithContext(ctx).Omit(clause.Associations).Save(room).Error
+}
+
+func (r *RoomRepository) SetAttendees(ctx context.Context, roomID uuid.UUID, emails []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("room_id = ?", roomID).Delete(&models.RoomAttendee{}).Error; err != nil {
+			return err
+		}
+		if len(emails) == 0 {
+			return nil
+		}
+		rows := make([]models.RoomAttendee, 0, len(emails))
+		for _, e := range emails {
+			rows = append(rows, models.RoomAttendee{RoomID: roomID, Email: e})
+		}
+		return tx.Create(&rows).Error
+	})
+}
+
+func (r *RoomRepository) GetAttendees(ctx context.Context, roomID string) ([]string, error) {
+	var emails []string
+	err := r.db.WithContext(ctx).
+		Model(&models.RoomAttendee{}).
+		Where("room_id = ?", roomID).
+		Pluck("email", &emails).Error
+	return emails, err
+}
+
+func (r *RoomRepository) ListUpcomingRooms(ctx context.Context, userID, email string, from time.Time) ([]models.Room, error) {
+	var rooms []models.Room
+	err := r.db.WithContext(ctx).
+		Where("is_active AND starts_at IS NOT NULL AND (ends_at >= ? OR rrule <> '')", from).
+		Where("owner_id = ? OR id IN (?) OR id IN (?)", userID,
+			r.db.Model(&models.RoomCoOwner{}).Select("room_id").Where("user_id = ?", userID),
+			r.db.Model(&models.RoomAttendee{}).Select("room_id").Where("lower(email) = lower(?)", email)).
+		Order("starts_at").
+		Find(&rooms).Error
+	return rooms, err
+}
+
+func (r *RoomRepository) GetOccurrenceOverrides(ctx context.Context, roomID string) ([]models.RoomOccurrence, error) {
+	var out []models.RoomOccurrence
+	err := r.db.WithContext(ctx).
+		Where("room_id = ?", roomID).
+		Order("original_start").
+		Find(&out).Error
+	return out, err
+}
+
+func (r *RoomRepository) SaveOccurrenceOverride(ctx context.Context, o *models.RoomOccurrence) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "room_id"}, {Name: "original_start"}},
+			DoUpdates: clause.AssignmentColumns([]string{"starts_at", "ends_at", "title", "description", "cancelled", "sequence"}),
+		}).
+		Create(o).Error
+}
+
+func (r *RoomRepository) DeleteOccurrenceOverrides(ctx context.Context, roomID string) error {
+	return r.db.WithContext(ctx).
+		Delete(&models.RoomOccurrence{}, "room_id = ?", roomID).Error
+}
+
+// Usage scopes: rooms owned by a user or rooms belonging to an organization.
+const (
+	ByOwner = "owner_id"
+	ByOrg   = "org_id"
+)
+
+func (r *RoomRepository) CountRoomsCreated(ctx context.Context, by, id string, since time.Time) (int64, error) {
+	var n int64
+	err := r.db.WithContext(ctx).
+		Model(&models.Room{}).
+		Where(by+" = ? AND parent_id IS NULL AND created_at >= ?", id, since).
+		Count(&n).Error
+	return n, err
+}
+
+// LiveRoomIDs returns the rooms in scope that have open participations.
+func (r *RoomRepository) LiveRoomIDs(ctx context.Context, by, id string) ([]string, error) {
+	var ids []string
+	err := r.db.WithContext(ctx).
+		Model(&models.Participant{}).
+		Distinct("participants.room_id").
+		Joins("JOIN rooms ON rooms.id = participants.room_id").
+		Where("participants.left_at IS NULL AND rooms."+by+" = ?", id).
+		Pluck("participants.room_id", &ids).Error
+	return ids, err
+}
+
+// ParticipantMinutes sums the time spent by all participants in rooms in
+// scope since the given time, counting open participations up to now.
+func (r *RoomRepository) ParticipantMinutes(ctx context.Context, by, id string, since time.Time) (int64, error) {
+	var secs float64
+	err := r.db.WithContext(ctx).
+		Model(&models.Participant{}).
+		Select("COALESCE(SUM(EXTRACT(EPOCH FROM COALESCE(participants.left_at, now()) - GREATEST(participants.joined_at, ?))), 0)", since).
+		Joins("JOIN rooms ON rooms.id = participants.room_id").
+		Where("rooms."+by+" = ? AND COALESCE(participants.left_at, now()) > ?", id, since).
+		Scan(&secs).Error
+	return int64(secs / 60), err
+}
+
+func (r *RoomRepository) ListOpenParticipations(ctx context.Context, joinedBefore time.Time) ([]models.Participant, error) {
+	var out []models.Participant
+	err := r.db.WithContext(ctx).
+		Where("left_at IS NULL AND joined_at < ?", joinedBefore).
+		Find(&out).Error
+	return out, err
+}
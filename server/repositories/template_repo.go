@@ -0,0 +1,47 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"video-conference/models"
+
+	"gorm.io/gorm"
+)
+
+type TemplateRepository struct{ db *gorm.DB }
+
+func NewTemplateRepository(db *gorm.DB) *TemplateRepository { return &TemplateRepository{db: db} }
+
+func (r *TemplateRepository) CreateTemplate(ctx context.Context, t *models.RoomTemplate) error {
+	return r.db.WithContext(ctx).Create(t).Error
+}
+
+func (r *TemplateRepository) UpdateTemplate(ctx context.Context, t *models.RoomTemplate) error {
+	return r.db.WithContext(ctx).Save(t).Error
+}
+
+func (r *TemplateRepository) GetTemplate(ctx context.Context, id string) (*models.RoomTemplate, error) {
+	var t models.RoomTemplate
+	err := r.db.WithContext(ctx).
+		First(&t, "id = ?", id).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &t, err
+}
+
+func (r *TemplateRepository) ListTemplates(ctx context.Context, ownerID string) ([]models.RoomTemplate, error) {
+	var out []models.RoomTemplate
+	err := r.db.WithContext(ctx).
+		Where("owner_id = ?", ownerID).
+		Order("name").
+		Find(&out).Error
+	return out, err
+}
+
+func (r *TemplateRepository) DeleteTemplate(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).
+		Delete(&models.RoomTemplate{}, "id = ?", id).Error
+}
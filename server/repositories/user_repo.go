@@ -7,6 +7,7 @@ import (
 
 	"video-conference/models"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
@@ -14,8 +15,19 @@ type UserRepository struct{ db *gorm.DB }
 
 func NewUserRepository(db *gorm.DB) *UserRepository { return &UserRepository{db: db} }
 
+// CreateUser also makes the user a member of their home organization, if
+// one is set.
 func (r *UserRepository) CreateUser(ctx context.Context, u *models.User) error {
-	if err := r.db.WithContext(ctx).Create(u).Error; err != nil {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(u).Error; err != nil {
+			return err
+		}
+		if u.OrgID == nil {
+			return nil
+		}
+		return tx.Omit("Org", "User").Create(&models.OrgMembership{OrgID: *u.OrgID, UserID: u.ID, Role: models.OrgMember}).Error
+	})
+	if err != nil {
 		return fmt.Errorf("create user: %w", err)
 	}
 	return nil
@@ -44,6 +56,51 @@ func (r *UserRepository) GetUserByID(ctx context.Context, id string) (*models.Us
 	return &u, err
 }
 
+func (r *UserRepository) GetUserByFeedToken(ctx context.Context, token string) (*models.User, error) {
+	var u models.User
+	err := r.db.WithContext(ctx).
+		Where("feed_token = ?", token).
+		First(&u).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &u, err
+}
+
+func (r *UserRepository) SetFeedToken(ctx context.Context, id string, token string) error {
+	return r.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", id).
+		Update("feed_token", token).Error
+}
+
+// SetPersonalRoom replaces the user's personal room prev, nil for none,
+// with roomID, and reports whether prev was still current.
+func (r *UserRepository) SetPersonalRoom(ctx context.Context, id string, prev *uuid.UUID, roomID uuid.UUID) (bool, error) {
+	q := r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id)
+	if prev == nil {
+		q = q.Where("personal_room_id IS NULL")
+	} else {
+		q = q.Where("personal_room_id = ?", *prev)
+	}
+	res := q.Update("personal_room_id", roomID)
+	return res.RowsAffected > 0, res.Error
+}
+
+// ListWithoutPersonalRoom pages through registered users that have no
+// personal room yet, in ID order after the given one. Guests have no email
+// and get no room.
+func (r *UserRepository) ListWithoutPersonalRoom(ctx context.Context, after uuid.UUID, limit int) ([]models.User, error) {
+	var out []models.User
+	err := r.db.WithContext(ctx).
+		Where("personal_room_id IS NULL AND email <> '' AND id > ?", after).
+		Order("id").
+		Limit(limit).
+		Find(&out).Error
+	return out, err
+}
+
 func (r *UserRepository) CreateSession(ctx context.Context, s *models.Session) error {
 	return r.db.WithContext(ctx).Create(s).Error
 }
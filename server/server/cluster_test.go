@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"video-conference/protocol"
+	"video-conference/repositories"
+
+	fastws "github.com/fasthttp/websocket"
+)
+
+// twoNodes starts two nodes sharing one Redis and database, with owner's
+// room created on a and guest joined to it through b.
+func twoNodes(t *testing.T) (tc *testCluster, a, b *testNode, owner, guest testUser, roomID string) {
+	t.Helper()
+	tc = newTestCluster(t)
+	a, b = tc.start(t, "node-a"), tc.start(t, "node-b")
+	owner = a.register(t, "owner")
+	guest = b.register(t, "guest")
+	roomID = a.createRoom(t, owner)
+	if status, out := b.call(t, guest, http.MethodPost, "/room/join/"+roomID, nil); status != http.StatusOK {
+		t.Fatalf("join: got %d %v", status, out)
+	}
+	return tc, a, b, owner, guest, roomID
+}
+
+func (tc *testCluster) participant(t *testing.T, roomID, userID string) bool {
+	t.Helper()
+	ok, err := repositories.NewRoomRepository(tc.rdb, tc.db).IsParticipant(context.Background(), roomID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestOfferCrossesNodes(t *testing.T) {
+	_, a, b, owner, guest, roomID := twoNodes(t)
+
+	host := a.dial(t, owner, roomID)
+	host.await(t, protocol.TypeSession)
+	peer := b.dial(t, guest, roomID)
+	peer.await(t, protocol.TypeSession)
+
+	joined := host.await(t, protocol.TypeUserJoined)
+	if joined["userID"] != guest.id || joined["seq"] == nil {
+		t.Fatalf("host saw %v, want a numbered user-joined for %s", joined, guest.id)
+	}
+
+	host.send(t, map[string]any{"type": protocol.TypeOffer, "to": guest.id, "offer": map[string]string{"sdp": "v=0"}})
+	offer := peer.await(t, protocol.TypeOffer)
+	if offer["from"] != owner.id || offer["offer"].(map[string]any)["sdp"] != "v=0" {
+		t.Fatalf("peer got %v, want the host's offer", offer)
+	}
+
+	peer.send(t, map[string]any{"type": protocol.TypeAnswer, "to": owner.id, "toConn": offer["fromConn"], "answer": map[string]string{"sdp": "v=0"}})
+	if answer := host.await(t, protocol.TypeAnswer); answer["from"] != guest.id {
+		t.Fatalf("host got %v, want the peer's answer", answer)
+	}
+}
+
+func TestClosingLastConnectionLeavesEveryNode(t *testing.T) {
+	tc, a, b, owner, guest, roomID := twoNodes(t)
+
+	host := a.dial(t, owner, roomID)
+	host.await(t, protocol.TypeSession)
+	peer := b.dial(t, guest, roomID)
+	peer.await(t, protocol.TypeSession)
+	host.await(t, protocol.TypeUserJoined)
+
+	msg := fastws.FormatCloseMessage(fastws.CloseNormalClosure, "bye")
+	if err := peer.conn.WriteControl(fastws.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if left := host.await(t, protocol.TypeUserLeft); left["userID"] != guest.id {
+		t.Fatalf("host saw %v, want user-left for %s", left, guest.id)
+	}
+	if tc.participant(t, roomID, guest.id) {
+		t.Fatal("guest is still a participant after leaving")
+	}
+	if !tc.participant(t, roomID, owner.id) {
+		t.Fatal("host was removed")
+	}
+}
+
+func TestDeadNodeIsReaped(t *testing.T) {
+	tc, a, b, owner, guest, roomID := twoNodes(t)
+
+	host := b.dial(t, owner, roomID)
+	host.await(t, protocol.TypeSession)
+	lost := a.dial(t, guest, roomID)
+	lost.await(t, protocol.TypeSession)
+	host.await(t, protocol.TypeUserJoined)
+
+	// Node a stops beating and node b outlives its heartbeat.
+	a.halt()
+	time.Sleep(2 * testHeartbeat)
+	tc.redis.FastForward(2 * testTTL)
+
+	if left := host.await(t, protocol.TypeUserLeft); left["userID"] != guest.id {
+		t.Fatalf("host saw %v, want user-left for %s", left, guest.id)
+	}
+	if tc.participant(t, roomID, guest.id) {
+		t.Fatal("user on the dead node is still a participant")
+	}
+	if !tc.participant(t, roomID, owner.id) {
+		t.Fatal("user on the live node was removed")
+	}
+}
+
+func TestLeavingAfterResumeOnAnotherNode(t *testing.T) {
+	tc := newTestCluster(t)
+	tc.resumeGrace = 500 * time.Millisecond
+	a, b := tc.start(t, "node-a"), tc.start(t, "node-b")
+	owner := a.register(t, "owner")
+	guest := a.register(t, "guest")
+	roomID := a.createRoom(t, owner)
+
+	host := a.dial(t, owner, roomID)
+	host.await(t, protocol.TypeSession)
+	dropped := b.dial(t, guest, roomID)
+	session := dropped.await(t, protocol.TypeSession)
+	host.await(t, protocol.TypeUserJoined)
+
+	_ = dropped.conn.Close()
+	host.await(t, protocol.TypeUserReconnecting)
+	resumed := a.dial(t, guest, roomID, "resume="+session["resumeToken"].(string), fmt.Sprintf("seq=%d", int64(session["lastSeq"].(float64))))
+	if msg := resumed.await(t, protocol.TypeSession); msg["resumed"] != true {
+		t.Fatalf("got %v, want a resumed session", msg)
+	}
+	host.await(t, protocol.TypeUserReconnected)
+
+	// Leaving before node b's resume window is over.
+	msg := fastws.FormatCloseMessage(fastws.CloseNormalClosure, "bye")
+	if err := resumed.conn.WriteControl(fastws.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if left := host.await(t, protocol.TypeUserLeft); left["userID"] != guest.id {
+		t.Fatalf("host saw %v, want user-left for %s", left, guest.id)
+	}
+	if tc.participant(t, roomID, guest.id) {
+		t.Fatal("guest is still a participant after leaving")
+	}
+}
+
+func TestWebinarAttendeeCapCountsEveryNode(t *testing.T) {
+	tc := newTestCluster(t)
+	a, b := tc.start(t, "node-a"), tc.start(t, "node-b")
+	owner := a.register(t, "owner")
+	first := a.register(t, "first")
+	second := b.register(t, "second")
+	status, out := a.call(t, owner, http.MethodPost, "/room/", map[string]any{"title": "keynote", "type": "webinar", "maxAttendees": 1})
+	if status != http.StatusOK {
+		t.Fatalf("create room: %d %v", status, out)
+	}
+	roomID := out["message"].(map[string]any)["id"].(string)
+	for _, j := range []struct {
+		n *testNode
+		u testUser
+	}{{a, first}, {b, second}} {
+		if status, out := j.n.call(t, j.u, http.MethodPost, "/room/join/"+roomID, nil); status != http.StatusOK {
+			t.Fatalf("join: got %d %v", status, out)
+		}
+	}
+
+	a.dial(t, first, roomID).await(t, protocol.TypeSession)
+	if msg := b.dial(t, second, roomID).await(t, protocol.TypeError); msg["code"] != protocol.CodeRoomFull {
+		t.Fatalf("got %v, want %s", msg, protocol.CodeRoomFull)
+	}
+	// Panelists have seats of their own.
+	b.dial(t, owner, roomID).await(t, protocol.TypeSession)
+}
@@ -2,9 +2,14 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
 	"time"
 
 	"video-conference/models"
+	"video-conference/services"
 	"video-conference/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -26,6 +31,12 @@ func (s *Server) handleRegister(c *fiber.Ctx) error {
 	if err != nil {
 		return utils.RespondWithError(c, fiber.StatusConflict, "registration failed")
 	}
+	// Failing here is not fatal: the room is created on first use instead.
+	if org, err := s.orgSvc.HomeOrg(c.Context(), uid, ""); err == nil {
+		if _, err := s.roomSvc.PersonalRoom(c.Context(), uid, org); err != nil {
+			log.Printf("register %s: personal room: %v", uid, err)
+		}
+	}
 
 	s.authSvc.SetAuthCookies(c, acc, ref, uid)
 	return utils.SuccessResponse(c, nil)
@@ -67,24 +78,65 @@ func (s *Server) handleRefresh(c *fiber.Ctx) error {
 
 func (s *Server) handleCreateRoom(c *fiber.Ctx) error {
 	var body struct {
-		Title       string `json:"title"`
-		Description string `json:"description"`
+		Title        string          `json:"title"`
+		Description  string          `json:"description"`
+		InviteOnly   bool            `json:"inviteOnly"`
+		Type         models.RoomType `json:"type"`
+		MaxAttendees int             `json:"maxAttendees"`
+		TemplateID   string          `json:"templateId"`
+		Settings     json.RawMessage `json:"settings"`
+		Tags         []string        `json:"tags"`
+		Discoverable bool            `json:"discoverable"`
+		OrgID        string          `json:"orgId"`
+		Shared       bool            `json:"shared"`
 	}
 	if err := c.BodyParser(&body); err != nil {
 		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
 	}
-
+	tags, err := services.NormalizeTags(body.Tags)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
 	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	org, err := s.orgSvc.HomeOrg(c.Context(), owner.String(), body.OrgID)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	settings, err := s.tplSvc.BuildSettings(c.Context(), owner, org, body.TemplateID, body.Settings)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	if err := s.quotaSvc.CheckCreate(c.Context(), owner.String(), org); err != nil {
+		return respondWithRoomError(c, err)
+	}
+	if body.Type == "" {
+		body.Type = models.RoomMeeting
+	}
+	if !body.Type.Valid() || body.MaxAttendees < 0 {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad room type")
+	}
+	if body.Type == models.RoomWebinar && body.MaxAttendees == 0 {
+		body.MaxAttendees = 500
+	}
+
 	room := models.Room{
 		ID:              uuid.New(),
 		OwnerID:         owner,
 		Title:           body.Title,
 		Description:     body.Description,
+		OrgID:           &org.ID,
+		Shared:          body.Shared,
+		Tags:            tags,
+		Discoverable:    body.Discoverable,
+		Type:            body.Type,
 		MaxParticipants: 10,
+		MaxAttendees:    body.MaxAttendees,
 		IsActive:        true,
+		InviteOnly:      body.InviteOnly,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
 	}
+	room.ApplySettings(settings)
 	if err := s.roomRepo.CreateRoom(c.Context(), &room); err != nil {
 		return utils.RespondWithError(c, fiber.StatusInternalServerError, "create failed")
 	}
@@ -96,20 +148,407 @@ func (s *Server) handleCreateRoom(c *fiber.Ctx) error {
 }
 
 func (s *Server) handleJoinRoom(c *fiber.Ctx) error {
-	roomID := c.Params("id")
 	user := uuid.MustParse(c.Cookies("videoConferenceUserId"))
 
-	room, err := s.roomRepo.GetRoom(c.Context(), roomID)
+	room, _, err := s.roomSvc.Resolve(c.Context(), c.Params("id"))
 	if err != nil || !room.IsActive {
 		return utils.RespondWithError(c, fiber.StatusNotFound, "room not found")
 	}
-	if err := s.roomRepo.AddParticipant(c.Context(), roomID, user.String()); err != nil {
+	if e := s.admissionError(c.Context(), room, user.String()); e != nil {
+		return utils.RespondWithError(c, e.Code, e.Message)
+	}
+	if err := s.quotaSvc.CheckJoin(c.Context(), room, user.String()); err != nil {
+		return respondWithRoomError(c, err)
+	}
+	if err := s.roomRepo.AddParticipant(c.Context(), room.ID.String(), user.String()); err != nil {
 		return utils.RespondWithError(c, fiber.StatusInternalServerError, "join failed")
 	}
 
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "slug": room.Slug})
+}
+
+func (s *Server) handleScheduleRoom(c *fiber.Ctx) error {
+	var body services.ScheduleInput
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	org, err := s.orgSvc.HomeOrg(c.Context(), owner.String(), body.OrgID)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	if err := s.quotaSvc.CheckCreate(c.Context(), owner.String(), org); err != nil {
+		return respondWithRoomError(c, err)
+	}
+	room, err := s.schedSvc.Schedule(c.Context(), owner, org, body)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "joinUrl": s.schedSvc.JoinURL(room.ID)})
+}
+
+func (s *Server) handleRescheduleRoom(c *fiber.Ctx) error {
+	var body services.ScheduleInput
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	room, err := s.schedSvc.Reschedule(c.Context(), owner, c.Params("id"), body)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "joinUrl": s.schedSvc.JoinURL(room.ID)})
+}
+
+func (s *Server) handleCancelRoom(c *fiber.Ctx) error {
+	var occurrence *time.Time
+	if raw := c.Query("occurrence"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return utils.RespondWithError(c, fiber.StatusBadRequest, "bad occurrence")
+		}
+		occurrence = &t
+	}
+
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	if err := s.schedSvc.Cancel(c.Context(), owner, c.Params("id"), occurrence); err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, nil)
+}
+
+func (s *Server) handleUpcomingRooms(c *fiber.Ctx) error {
+	from, to := time.Now(), time.Now().AddDate(0, 0, 30)
+	if raw := c.Query("from"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return utils.RespondWithError(c, fiber.StatusBadRequest, "bad from")
+		}
+		from = t
+	}
+	if raw := c.Query("to"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil || !t.After(from) {
+			return utils.RespondWithError(c, fiber.StatusBadRequest, "bad to")
+		}
+		to = t
+	}
+
+	list, err := s.schedSvc.Upcoming(c.Context(), c.Cookies("videoConferenceUserId"), from, to)
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusInternalServerError, "listing failed")
+	}
+	return utils.SuccessResponse(c, list)
+}
+
+func (s *Server) handleCalendarFeedURL(c *fiber.Ctx) error {
+	url, err := s.schedSvc.FeedURL(c.Context(), c.Cookies("videoConferenceUserId"))
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusNotFound, "user not found")
+	}
+	return utils.SuccessResponse(c, fiber.Map{"url": url})
+}
+
+func (s *Server) handleCalendarFeed(c *fiber.Ctx) error {
+	token := strings.TrimSuffix(c.Params("feed"), ".ics")
+	body, err := s.schedSvc.Feed(c.Context(), token)
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	return c.SendString(body)
+}
+
+func respondWithRoomError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidSchedule), errors.Is(err, services.ErrInvalidSlug),
+		errors.Is(err, models.ErrInvalidSettings), errors.Is(err, services.ErrInvalidOwner),
+		errors.Is(err, services.ErrPersonalRoom), errors.Is(err, services.ErrInvalidTags),
+		errors.Is(err, services.ErrInvalidOrg), errors.Is(err, services.ErrInvalidOrgRole),
+		errors.Is(err, models.ErrOrgLimit):
+		return utils.RespondWithError(c, fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrSlugTaken), errors.Is(err, services.ErrLastOrgOwner):
+		return utils.RespondWithError(c, fiber.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrRoomNotFound), errors.Is(err, services.ErrTemplateNotFound),
+		errors.Is(err, services.ErrOrgNotFound), errors.Is(err, services.ErrMeetingNotFound):
+		return utils.RespondWithError(c, fiber.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrNotRoomOwner), errors.Is(err, services.ErrNotOrgMember),
+		errors.Is(err, services.ErrNotOrgAdmin), errors.Is(err, services.ErrQuotaExceeded):
+		return utils.RespondWithError(c, fiber.StatusForbidden, err.Error())
+	}
+	return utils.RespondWithError(c, fiber.StatusInternalServerError, "room request failed")
+}
+
+func (s *Server) handleInvite(c *fiber.Ctx) error {
+	var body struct {
+		Invitees []string `json:"invitees"`
+	}
+	if err := c.BodyParser(&body); err != nil || len(body.Invitees) == 0 {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	invs, err := s.invSvc.Invite(c.Context(), owner, c.Params("id"), body.Invitees)
+	switch {
+	case errors.Is(err, services.ErrInvalidInvitee):
+		return utils.RespondWithError(c, fiber.StatusBadRequest, err.Error())
+	case err != nil:
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, invs)
+}
+
+func (s *Server) handleSetInviteOnly(c *fiber.Ctx) error {
+	return s.setRoomFlag(c, "inviteOnly", func(r *models.Room) *bool { return &r.InviteOnly })
+}
+
+func (s *Server) handleSetDiscoverable(c *fiber.Ctx) error {
+	return s.setRoomFlag(c, "discoverable", func(r *models.Room) *bool { return &r.Discoverable })
+}
+
+func (s *Server) handleSetShared(c *fiber.Ctx) error {
+	return s.setRoomFlag(c, "shared", func(r *models.Room) *bool { return &r.Shared })
+}
+
+// setRoomFlag lets a room manager set the boolean field of the room that
+// flag points to from the request body's name property.
+func (s *Server) setRoomFlag(c *fiber.Ctx, name string, flag func(*models.Room) *bool) error {
+	var body map[string]bool
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	room, err := s.roomRepo.GetRoom(c.Context(), c.Params("id"))
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusNotFound, "room not found")
+	}
+	if !room.IsManager(c.Cookies("videoConferenceUserId")) {
+		return utils.RespondWithError(c, fiber.StatusForbidden, "not the room owner")
+	}
+	*flag(room) = body[name]
+	if err := s.roomRepo.UpdateRoom(c.Context(), room); err != nil {
+		return utils.RespondWithError(c, fiber.StatusInternalServerError, "update failed")
+	}
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, name: *flag(room)})
+}
+
+func (s *Server) handleSetTags(c *fiber.Ctx) error {
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	actor := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	room, err := s.roomSvc.SetTags(c.Context(), actor, c.Params("id"), body.Tags)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "tags": room.Tags})
+}
+
+func (s *Server) handleSearchRooms(c *fiber.Ctx) error {
+	var tags []string
+	if raw := c.Query("tags"); raw != "" {
+		tags = strings.Split(raw, ",")
+	}
+
+	hits, err := s.roomSvc.Search(c.Context(), c.Cookies("videoConferenceUserId"),
+		c.Query("q"), tags, c.QueryInt("limit"), c.QueryInt("offset"))
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, hits)
+}
+
+func (s *Server) handlePendingInvitations(c *fiber.Ctx) error {
+	invs, err := s.invSvc.Pending(c.Context(), c.Cookies("videoConferenceUserId"))
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusNotFound, "user not found")
+	}
+	return utils.SuccessResponse(c, invs)
+}
+
+func (s *Server) handleAcceptInvitation(c *fiber.Ctx) error {
+	return s.respondToInvitation(c, true)
+}
+
+func (s *Server) handleDeclineInvitation(c *fiber.Ctx) error {
+	return s.respondToInvitation(c, false)
+}
+
+func (s *Server) respondToInvitation(c *fiber.Ctx, accept bool) error {
+	inv, err := s.invSvc.Respond(c.Context(), c.Cookies("videoConferenceUserId"), c.Params("id"), accept)
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusNotFound, "invitation not found")
+	}
+	return utils.SuccessResponse(c, fiber.Map{"id": inv.ID, "roomID": inv.RoomID, "status": inv.Status})
+}
+
+func (s *Server) handlePersonalRoom(c *fiber.Ctx) error {
+	uid := c.Cookies("videoConferenceUserId")
+	org, err := s.orgSvc.HomeOrg(c.Context(), uid, "")
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	room, err := s.roomSvc.PersonalRoom(c.Context(), uid, org)
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusInternalServerError, "personal room unavailable")
+	}
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "slug": room.Slug})
+}
+
+func (s *Server) handleSetSlug(c *fiber.Ctx) error {
+	var body struct {
+		Slug string `json:"slug"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	room, err := s.roomSvc.SetSlug(c.Context(), owner, c.Params("id"), body.Slug)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "slug": room.Slug})
+}
+
+func (s *Server) handleTransferRoom(c *fiber.Ctx) error {
+	var body struct {
+		NewOwner   string `json:"newOwner"`
+		KeepAccess bool   `json:"keepAccess"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.NewOwner == "" {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	actor := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	room, err := s.roomSvc.TransferOwnership(c.Context(), actor, c.Params("id"), body.NewOwner, body.KeepAccess)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "ownerId": room.OwnerID, "coOwners": room.CoOwners})
+}
+
+func (s *Server) handleAddCoOwner(c *fiber.Ctx) error {
+	var body struct {
+		User string `json:"user"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.User == "" {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	actor := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	room, err := s.roomSvc.AddCoOwner(c.Context(), actor, c.Params("id"), body.User)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "ownerId": room.OwnerID, "coOwners": room.CoOwners})
+}
+
+func (s *Server) handleRemoveCoOwner(c *fiber.Ctx) error {
+	actor := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	room, err := s.roomSvc.RemoveCoOwner(c.Context(), actor, c.Params("id"), c.Params("userID"))
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "ownerId": room.OwnerID, "coOwners": room.CoOwners})
+}
+
+func (s *Server) handleCloseRoom(c *fiber.Ctx) error {
+	actor := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	room, err := s.roomSvc.Managed(c.Context(), actor, c.Params("id"))
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	if err := s.wsSvc.EndMeeting(c.Context(), room.ID.String(), actor.String()); err != nil {
+		return utils.RespondWithError(c, fiber.StatusInternalServerError, "close failed")
+	}
 	return utils.SuccessResponse(c, fiber.Map{"id": room.ID})
 }
 
+func (s *Server) handleResolveSlug(c *fiber.Ctx) error {
+	room, canonical, err := s.roomSvc.Resolve(c.Context(), c.Params("slug"))
+	if err != nil || !room.IsActive {
+		return utils.RespondWithError(c, fiber.StatusNotFound, "room not found")
+	}
+	if canonical != "" {
+		return c.Redirect("/video-conference/r/"+canonical, fiber.StatusMovedPermanently)
+	}
+	return utils.SuccessResponse(c, fiber.Map{"id": room.ID, "slug": room.Slug, "title": room.Title})
+}
+
+func (s *Server) handleUpdateRoomSettings(c *fiber.Ctx) error {
+	var body json.RawMessage
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	settings, err := s.tplSvc.UpdateRoomSettings(c.Context(), owner, c.Params("id"), body)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, settings)
+}
+
+func (s *Server) handleListTemplates(c *fiber.Ctx) error {
+	list, err := s.tplSvc.List(c.Context(), c.Cookies("videoConferenceUserId"))
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusInternalServerError, "listing failed")
+	}
+	return utils.SuccessResponse(c, list)
+}
+
+type templateBody struct {
+	Name     string          `json:"name"`
+	Settings json.RawMessage `json:"settings"`
+}
+
+func (s *Server) handleCreateTemplate(c *fiber.Ctx) error {
+	var body templateBody
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	t, err := s.tplSvc.Create(c.Context(), owner, body.Name, body.Settings)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, t)
+}
+
+func (s *Server) handleUpdateTemplate(c *fiber.Ctx) error {
+	var body templateBody
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	t, err := s.tplSvc.Update(c.Context(), owner, c.Params("id"), body.Name, body.Settings)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, t)
+}
+
+func (s *Server) handleDeleteTemplate(c *fiber.Ctx) error {
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	if err := s.tplSvc.Delete(c.Context(), owner, c.Params("id")); err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, nil)
+}
+
 func (s *Server) handleUserInfo(c *fiber.Ctx) error {
 	uid := c.Params("id")
 	if u, _ := s.userRepo.GetUserByID(c.Context(), uid); u != nil {
@@ -118,25 +557,207 @@ func (s *Server) handleUserInfo(c *fiber.Ctx) error {
 	return utils.RespondWithError(c, fiber.StatusNotFound, "user not found")
 }
 
+func (s *Server) handleWSAdmission(c *fiber.Ctx) error {
+	if s.wsSvc.Draining() {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "server draining")
+	}
+	uid := c.Locals("videoConferenceUserId").(string)
+
+	room, _, err := s.roomSvc.Resolve(c.Context(), c.Params("roomID"))
+	if err != nil || !room.IsActive {
+		return fiber.NewError(fiber.StatusNotFound, "room not found")
+	}
+	if e := s.admissionError(c.Context(), room, uid); e != nil {
+		return e
+	}
+	c.Locals("roomID", room.ID.String())
+	return c.Next()
+}
+
+func (s *Server) admissionError(ctx context.Context, room *models.Room, uid string) *fiber.Error {
+	if banned, _ := s.roomRepo.IsBanned(ctx, room.ID.String(), uid); banned {
+		return fiber.NewError(fiber.StatusForbidden, "banned from room")
+	}
+	if s.isLockedOut(ctx, room, uid) {
+		return fiber.NewError(fiber.StatusForbidden, "room locked")
+	}
+	if !s.orgSvc.CanAccess(ctx, room, uid) {
+		return fiber.NewError(fiber.StatusForbidden, "not an organization member")
+	}
+	if !s.invSvc.CanJoin(ctx, room, uid) {
+		return fiber.NewError(fiber.StatusForbidden, "invite only")
+	}
+	if !room.Settings.AllowGuests && !room.IsManager(uid) {
+		if u, _ := s.userRepo.GetUserByID(ctx, uid); u == nil || u.Email == "" {
+			return fiber.NewError(fiber.StatusForbidden, "guests not allowed")
+		}
+	}
+	return nil
+}
+
+func (s *Server) isLockedOut(ctx context.Context, room *models.Room, uid string) bool {
+	if room.IsManager(uid) {
+		return false
+	}
+	roomID := room.ID.String()
+	if locked, _ := s.roomRepo.IsLocked(ctx, roomID); !locked {
+		return false
+	}
+	member, _ := s.roomRepo.IsParticipant(ctx, roomID, uid)
+	return !member
+}
+
 func (s *Server) handleWebSocket(conn *websocket.Conn) {
 	ctx := conn.Locals("ctx").(context.Context)
 	uid := conn.Locals("videoConferenceUserId").(string)
-	roomID := conn.Params("roomID")
+	roomID := conn.Locals("roomID").(string)
 
-	if r, _ := s.roomRepo.GetRoom(ctx, roomID); r == nil {
-		_ = conn.WriteJSON(fiber.Map{"error": "unknown room"})
-		_ = conn.Close()
-		return
+	s.wsSvc.HandleConnection(ctx, conn, roomID, uid)
+}
+
+func (s *Server) handleListOrgs(c *fiber.Ctx) error {
+	list, err := s.orgSvc.List(c.Context(), c.Cookies("videoConferenceUserId"))
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusInternalServerError, "listing failed")
+	}
+	return utils.SuccessResponse(c, list)
+}
+
+func (s *Server) handleCreateOrg(c *fiber.Ctx) error {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	owner := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	org, err := s.orgSvc.Create(c.Context(), owner, body.Name)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, org)
+}
+
+func (s *Server) handleGetOrg(c *fiber.Ctx) error {
+	org, m, err := s.orgSvc.Get(c.Context(), c.Cookies("videoConferenceUserId"), c.Params("id"))
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, fiber.Map{"org": org, "role": m.Role})
+}
+
+func (s *Server) handleUpdateOrg(c *fiber.Ctx) error {
+	var body services.OrgInput
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
 	}
 
-	ids, _ := s.roomRepo.GetParticipants(ctx, roomID)
-	list := make([]fiber.Map, 0, len(ids))
-	for _, id := range ids {
-		if u, _ := s.userRepo.GetUserByID(ctx, id); u != nil {
-			list = append(list, fiber.Map{"userID": u.ID, "userName": u.UserName, "imgUrl": u.ImgUrl})
+	org, err := s.orgSvc.Update(c.Context(), c.Cookies("videoConferenceUserId"), c.Params("id"), body)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, org)
+}
+
+func (s *Server) handleOrgMembers(c *fiber.Ctx) error {
+	members, err := s.orgSvc.Members(c.Context(), c.Cookies("videoConferenceUserId"), c.Params("id"))
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+
+	out := make([]fiber.Map, len(members))
+	for i, m := range members {
+		out[i] = fiber.Map{
+			"userId":   m.UserID,
+			"userName": m.User.UserName,
+			"email":    m.User.Email,
+			"imgUrl":   m.User.ImgUrl,
+			"role":     m.Role,
 		}
 	}
-	_ = conn.WriteJSON(fiber.Map{"type": "users-list", "users": list})
+	return utils.SuccessResponse(c, out)
+}
 
-	s.wsSvc.HandleConnection(ctx, conn, roomID, uid)
+func (s *Server) handleSetOrgMember(c *fiber.Ctx) error {
+	var body struct {
+		User string         `json:"user"`
+		Role models.OrgRole `json:"role"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.User == "" {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	err := s.orgSvc.SetMember(c.Context(), c.Cookies("videoConferenceUserId"), c.Params("id"), body.User, body.Role)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, nil)
+}
+
+func (s *Server) handleRemoveOrgMember(c *fiber.Ctx) error {
+	err := s.orgSvc.RemoveMember(c.Context(), c.Cookies("videoConferenceUserId"), c.Params("id"), c.Params("userID"))
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, nil)
+}
+
+func (s *Server) handleSetHomeOrg(c *fiber.Ctx) error {
+	var body struct {
+		OrgID string `json:"orgId"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return utils.RespondWithError(c, fiber.StatusBadRequest, "bad body")
+	}
+
+	org, err := s.orgSvc.SetHome(c.Context(), c.Cookies("videoConferenceUserId"), body.OrgID)
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, org)
+}
+
+func (s *Server) handleUserUsage(c *fiber.Ctx) error {
+	report, err := s.quotaSvc.UserUsage(c.Context(), c.Cookies("videoConferenceUserId"))
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusNotFound, "user not found")
+	}
+	return utils.SuccessResponse(c, report)
+}
+
+func (s *Server) handleOrgUsage(c *fiber.Ctx) error {
+	report, err := s.quotaSvc.OrgUsage(c.Context(), c.Cookies("videoConferenceUserId"), c.Params("id"))
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, report)
+}
+
+func (s *Server) handleMeetingSessions(c *fiber.Ctx) error {
+	actor := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	sessions, err := s.rptSvc.Sessions(c.Context(), actor, c.Params("id"))
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+	return utils.SuccessResponse(c, sessions)
+}
+
+func (s *Server) handleMeetingReport(c *fiber.Ctx) error {
+	actor := uuid.MustParse(c.Cookies("videoConferenceUserId"))
+	report, err := s.rptSvc.Report(c.Context(), actor, c.Params("id"), c.Params("sessionId"))
+	if err != nil {
+		return respondWithRoomError(c, err)
+	}
+
+	if c.Query("format") != "csv" && c.Accepts(fiber.MIMEApplicationJSON, "text/csv") != "text/csv" {
+		return utils.SuccessResponse(c, report)
+	}
+	data, err := report.CSV()
+	if err != nil {
+		return utils.RespondWithError(c, fiber.StatusInternalServerError, "report failed")
+	}
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="meeting-`+report.SessionID.String()+`.csv"`)
+	return c.Send(data)
 }
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"video-conference/models"
+	"video-conference/protocol"
+	"video-conference/repositories"
+	"video-conference/services"
+
+	"github.com/google/uuid"
+)
+
+func TestJoinEnforcesParticipantQuota(t *testing.T) {
+	tc := newTestCluster(t)
+	tc.plans["free"] = models.Plan{Name: "free", ConcurrentParticipants: 1}
+	node := tc.start(t, "node-a")
+	owner := node.register(t, "owner")
+	early := node.register(t, "early")
+	late := node.register(t, "late")
+
+	// Joining before anyone is connected is within the quota.
+	roomID := node.createRoom(t, owner)
+	if status, out := node.call(t, early, http.MethodPost, "/room/join/"+roomID, nil); status != http.StatusOK {
+		t.Fatalf("join: got %d %v", status, out)
+	}
+	host := node.dial(t, owner, roomID)
+	host.await(t, protocol.TypeSession)
+
+	status, out := node.call(t, late, http.MethodPost, "/room/join/"+roomID, nil)
+	if status != http.StatusForbidden || !strings.Contains(out["error"].(string), services.ErrQuotaExceeded.Error()) {
+		t.Fatalf("join: got %d %v, want %d with %q", status, out, http.StatusForbidden, services.ErrQuotaExceeded)
+	}
+
+	// Having joined over HTTP does not let a user past the quota on connect.
+	msg := node.dial(t, early, roomID).await(t, protocol.TypeError)
+	if msg["code"] != protocol.CodeQuotaExceeded {
+		t.Fatalf("connect: got %v, want %s", msg, protocol.CodeQuotaExceeded)
+	}
+
+	// The owner opening a second device is not a new participant.
+	node.dial(t, owner, roomID).await(t, protocol.TypeSession)
+}
+
+func TestPersonalRoomIsClaimedOnce(t *testing.T) {
+	tc := newTestCluster(t)
+	node := tc.start(t, "node-a")
+	u := node.register(t, "solo")
+	status, out := node.call(t, u, http.MethodGet, "/user/personal-room", nil)
+	if status != http.StatusOK {
+		t.Fatalf("personal room: got %d %v", status, out)
+	}
+	id := out["message"].(map[string]any)["id"].(string)
+
+	// A request that read the user before the room above was set loses.
+	users := repositories.NewUserRepository(tc.db)
+	if claimed, err := users.SetPersonalRoom(context.Background(), u.id, nil, uuid.New()); err != nil || claimed {
+		t.Fatalf("late claim: got %v %v, want refused", claimed, err)
+	}
+	if got, _ := users.GetUserByID(context.Background(), u.id); got.PersonalRoomID == nil || got.PersonalRoomID.String() != id {
+		t.Fatalf("personal room is %v, want %s", got.PersonalRoomID, id)
+	}
+}
+
+func TestBackfillSkipsGuests(t *testing.T) {
+	tc := newTestCluster(t)
+	guest := models.User{ID: uuid.New(), UserName: "Anonymous"}
+	if err := tc.db.Create(&guest).Error; err != nil {
+		t.Fatal(err)
+	}
+	users := repositories.NewUserRepository(tc.db)
+	rooms := repositories.NewRoomRepository(tc.rdb, tc.db)
+	services.NewRoomService(rooms, users, repositories.NewOrgRepository(tc.db)).BackfillPersonalRooms(context.Background())
+
+	u, err := users.GetUserByID(context.Background(), guest.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.PersonalRoomID != nil {
+		t.Fatal("guest was given a personal room")
+	}
+}
+
+func TestRoomFlags(t *testing.T) {
+	tc := newTestCluster(t)
+	node := tc.start(t, "node-a")
+	owner := node.register(t, "owner")
+	other := node.register(t, "other")
+	roomID := node.createRoom(t, owner)
+
+	for _, f := range []struct{ name, path string }{
+		{"inviteOnly", "invite-only"},
+		{"discoverable", "discoverable"},
+		{"shared", "shared"},
+	} {
+		name, path := f.name, "/room/"+roomID+"/"+f.path
+		if status, _ := node.call(t, other, http.MethodPut, path, map[string]bool{name: true}); status != http.StatusForbidden {
+			t.Fatalf("%s by another user: got %d, want %d", name, status, http.StatusForbidden)
+		}
+		status, out := node.call(t, owner, http.MethodPut, path, map[string]bool{name: true})
+		if status != http.StatusOK || out["message"].(map[string]any)[name] != true {
+			t.Fatalf("%s: got %d %v", name, status, out)
+		}
+	}
+	room, err := repositories.NewRoomRepository(tc.rdb, tc.db).GetRoom(context.Background(), roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !room.InviteOnly || !room.Discoverable || !room.Shared {
+		t.Fatalf("got inviteOnly=%v discoverable=%v shared=%v, want all set", room.InviteOnly, room.Discoverable, room.Shared)
+	}
+}
@@ -10,12 +10,14 @@ import (
 	"time"
 
 	"video-conference/config"
+	"video-conference/protocol"
 	"video-conference/repositories"
 	"video-conference/services"
 	"video-conference/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/expvar"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/websocket/v2"
@@ -26,17 +28,31 @@ type Server struct {
 	cfg      *config.Config
 	authSvc  *services.AuthService
 	wsSvc    *services.WebSocketService
+	schedSvc *services.ScheduleService
+	invSvc   *services.InvitationService
+	roomSvc  *services.RoomService
+	tplSvc   *services.TemplateService
+	orgSvc   *services.OrgService
+	quotaSvc *services.QuotaService
+	rptSvc   *services.ReportService
 	roomRepo *repositories.RoomRepository
 	userRepo *repositories.UserRepository
 }
 
 func New(cfg *config.Config, auth *services.AuthService,
 	ws *services.WebSocketService,
+	sched *services.ScheduleService,
+	inv *services.InvitationService,
+	rooms *services.RoomService,
+	tpl *services.TemplateService,
+	orgs *services.OrgService,
+	quotas *services.QuotaService,
+	reports *services.ReportService,
 	room *repositories.RoomRepository,
 	user *repositories.UserRepository,
 ) *Server {
 	app := fiber.New(fiber.Config{ErrorHandler: utils.GlobalErrorHandler})
-	return &Server{app, cfg, auth, ws, room, user}
+	return &Server{app, cfg, auth, ws, sched, inv, rooms, tpl, orgs, quotas, reports, room, user}
 }
 
 func (s *Server) SetupMiddleware() {
@@ -53,6 +69,22 @@ func (s *Server) SetupMiddleware() {
 	}))
 }
 
+// startMetrics serves /debug/vars on its own listener, which is meant to be
+// reachable only from inside the deployment.
+func (s *Server) startMetrics() *fiber.App {
+	if s.cfg.MetricsAddr == "" {
+		return nil
+	}
+	metrics := fiber.New(fiber.Config{DisableStartupMessage: true})
+	metrics.Use(expvar.New())
+	go func() {
+		if err := metrics.Listen(s.cfg.MetricsAddr); err != nil {
+			log.Printf("metrics listener: %v", err)
+		}
+	}()
+	return metrics
+}
+
 func (s *Server) SetupRoutes() {
 	api := s.app.Group("/video-conference")
 
@@ -63,16 +95,67 @@ func (s *Server) SetupRoutes() {
 
 	user := api.Group("/user", s.authSvc.AuthRequired)
 	user.Get("/userInfo/:id", s.handleUserInfo)
+	user.Get("/calendar-feed", s.handleCalendarFeedURL)
+	user.Get("/personal-room", s.handlePersonalRoom)
+	user.Get("/invitations", s.handlePendingInvitations)
+	user.Post("/invitations/:id/accept", s.handleAcceptInvitation)
+	user.Post("/invitations/:id/decline", s.handleDeclineInvitation)
+	user.Get("/templates", s.handleListTemplates)
+	user.Post("/templates", s.handleCreateTemplate)
+	user.Put("/templates/:id", s.handleUpdateTemplate)
+	user.Delete("/templates/:id", s.handleDeleteTemplate)
+	user.Put("/org", s.handleSetHomeOrg)
+	user.Get("/usage", s.handleUserUsage)
 	// user.Post("/updataUserInfo", s.handleUpdateUserInfo)
 
 	room := api.Group("/room", s.authSvc.AuthRequired)
 	room.Post("/", s.handleCreateRoom)
 	room.Post("/join/:id", s.handleJoinRoom)
+	room.Post("/schedule", s.handleScheduleRoom)
+	room.Get("/upcoming", s.handleUpcomingRooms)
+	room.Get("/search", s.handleSearchRooms)
+	room.Put("/:id/schedule", s.handleRescheduleRoom)
+	room.Delete("/:id/schedule", s.handleCancelRoom)
+	room.Post("/:id/invitations", s.handleInvite)
+	room.Put("/:id/invite-only", s.handleSetInviteOnly)
+	room.Put("/:id/discoverable", s.handleSetDiscoverable)
+	room.Put("/:id/shared", s.handleSetShared)
+	room.Put("/:id/tags", s.handleSetTags)
+	room.Put("/:id/slug", s.handleSetSlug)
+	room.Put("/:id/settings", s.handleUpdateRoomSettings)
+	room.Post("/:id/transfer", s.handleTransferRoom)
+	room.Post("/:id/co-owners", s.handleAddCoOwner)
+	room.Delete("/:id/co-owners/:userID", s.handleRemoveCoOwner)
+	room.Post("/:id/close", s.handleCloseRoom)
+	room.Get("/:id/sessions", s.handleMeetingSessions)
+	room.Get("/:id/sessions/:sessionId/report", s.handleMeetingReport)
+
+	org := api.Group("/org", s.authSvc.AuthRequired)
+	org.Get("/", s.handleListOrgs)
+	org.Post("/", s.handleCreateOrg)
+	org.Get("/:id", s.handleGetOrg)
+	org.Put("/:id", s.handleUpdateOrg)
+	org.Get("/:id/members", s.handleOrgMembers)
+	org.Put("/:id/members", s.handleSetOrgMember)
+	org.Delete("/:id/members/:userID", s.handleRemoveOrgMember)
+	org.Get("/:id/usage", s.handleOrgUsage)
+
+	api.Get("/r/:slug", s.authSvc.AuthRequired, s.handleResolveSlug)
+
+	api.Get("/calendar/:feed", s.handleCalendarFeed)
 
 	ws := api.Group("/ws", s.authSvc.AuthenticateWS)
-	ws.Get("/:roomID", websocket.New(s.handleWebSocket))
+	ws.Get("/:roomID", s.handleWSAdmission, websocket.New(s.handleWebSocket, websocket.Config{Subprotocols: protocol.Subprotocols}))
 
 	api.Get("/health", func(c *fiber.Ctx) error {
+		if s.wsSvc.Draining() {
+			// Load balancers stop routing here while clients move away.
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"status":      "draining",
+				"version":     "1.2.0",
+				"connections": s.wsSvc.Connections(),
+			})
+		}
 		return c.JSON(fiber.Map{"status": "healthy", "version": "1.2.0"})
 	})
 }
@@ -80,14 +163,19 @@ func (s *Server) SetupRoutes() {
 func (s *Server) Start() {
 	s.SetupMiddleware()
 	s.SetupRoutes()
+	metrics := s.startMetrics()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-quit
 		log.Println("graceful shutdown …")
+		s.wsSvc.Drain(context.Background(), s.cfg.DrainTimeout)
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
+		if metrics != nil {
+			_ = metrics.ShutdownWithContext(ctx)
+		}
 		_ = s.app.ShutdownWithContext(ctx)
 	}()
 
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"video-conference/config"
+	"video-conference/mailer"
+	"video-conference/models"
+	"video-conference/protocol"
+	"video-conference/repositories"
+	"video-conference/services"
+
+	"github.com/alicebob/miniredis/v2"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// These tests run whole nodes, HTTP and WebSocket included, against an
+// in-memory Redis and a throwaway SQLite database.
+
+const (
+	testHeartbeat = 50 * time.Millisecond
+	testTTL       = time.Second
+)
+
+// sqliteDefaults stands in for the Postgres column defaults the models use.
+var sqliteDefaults = map[string]string{
+	"now()": "CURRENT_TIMESTAMP",
+	"gen_random_uuid()": "(lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' || " +
+		"substr(lower(hex(randomblob(2))), 2) || '-a' || substr(lower(hex(randomblob(2))), 2) || '-' || " +
+		"lower(hex(randomblob(6))))",
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "test.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := []any{
+		&models.User{},
+		&models.Session{},
+		&models.Room{},
+		&models.RoomCoOwner{},
+		&models.Participant{},
+		&models.RoomAttendee{},
+		&models.RoomOccurrence{},
+		&models.Invitation{},
+		&models.RoomSlugRedirect{},
+		&models.RoomTemplate{},
+		&models.Organization{},
+		&models.OrgMembership{},
+		&models.MeetingSession{},
+		&models.AuditRecord{},
+	}
+	for _, m := range tables {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(m); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range stmt.Schema.Fields {
+			if d, ok := sqliteDefaults[f.DefaultValue]; ok {
+				f.DefaultValue = d
+			}
+		}
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatal(err)
+	}
+	org := models.Organization{ID: models.DefaultOrgID, Name: "Default", RoomDefaults: models.DefaultRoomSettings()}
+	if err := db.Create(&org).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type testCluster struct {
+	db          *gorm.DB
+	redis       *miniredis.Miniredis
+	rdb         *redis.Client
+	plans       map[string]models.Plan
+	resumeGrace time.Duration
+}
+
+func newTestCluster(t *testing.T) *testCluster {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return &testCluster{
+		db:    newTestDB(t),
+		redis: mr,
+		rdb:   rdb,
+		// Unlimited by default; the minutes query is Postgres-only.
+		plans:       map[string]models.Plan{"free": {Name: "free"}},
+		resumeGrace: 5 * time.Second,
+	}
+}
+
+type testNode struct {
+	id    string
+	base  string
+	wsSvc *services.WebSocketService
+	// halt stops the heartbeat, as if the node had died.
+	halt context.CancelFunc
+}
+
+// start runs a node the way main does, listening on a loopback port.
+func (tc *testCluster) start(t *testing.T, nodeID string) *testNode {
+	t.Helper()
+	cfg := &config.Config{
+		JWTSecret:      "test-secret",
+		MaxConnections: 10,
+		AppURL:         "http://app.test",
+		APIURL:         "http://api.test",
+		WSPingInterval: time.Second,
+		WSIdleTimeout:  5 * time.Second,
+		WSWriteTimeout: time.Second,
+		WSResumeGrace:  tc.resumeGrace,
+		NodeID:         nodeID,
+		DrainTimeout:   time.Second,
+		DefaultPlan:    "free",
+	}
+
+	userRepo := repositories.NewUserRepository(tc.db)
+	roomRepo := repositories.NewRoomRepository(tc.rdb, tc.db)
+	invRepo := repositories.NewInvitationRepository(tc.db)
+	tplRepo := repositories.NewTemplateRepository(tc.db)
+	orgRepo := repositories.NewOrgRepository(tc.db)
+	meetingRepo := repositories.NewMeetingRepository(tc.db)
+	auditRepo := repositories.NewAuditRepository(tc.db)
+	mail := mailer.LogMailer{}
+
+	quotaSvc := services.NewQuotaService(roomRepo, userRepo, orgRepo, tc.plans, cfg.DefaultPlan)
+	reportSvc := services.NewReportService(roomRepo, userRepo, meetingRepo, mail)
+	authSvc := services.NewAuthService(userRepo, cfg.JWTSecret)
+	wsSvc := services.NewWebSocketService(roomRepo, userRepo, quotaSvc, reportSvc, auditRepo,
+		nil, cfg.MaxConnections, cfg.WSPingInterval, cfg.WSIdleTimeout, cfg.WSWriteTimeout,
+		cfg.WSResumeGrace, nodeID, models.DefaultRateLimits(), 64*1024, 5)
+	schedSvc := services.NewScheduleService(roomRepo, userRepo, mail, cfg.AppURL, cfg.APIURL)
+	invSvc := services.NewInvitationService(invRepo, roomRepo, userRepo, mail, cfg.AppURL)
+	roomSvc := services.NewRoomService(roomRepo, userRepo, orgRepo)
+	tplSvc := services.NewTemplateService(tplRepo, roomRepo, orgRepo)
+	orgSvc := services.NewOrgService(orgRepo, userRepo)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := roomRepo.BeatNode(ctx, nodeID, testTTL); err != nil {
+		t.Fatal(err)
+	}
+	go wsSvc.RunHeartbeat(ctx, testHeartbeat, testTTL)
+
+	srv := New(cfg, authSvc, wsSvc, schedSvc, invSvc, roomSvc, tplSvc, orgSvc, quotaSvc, reportSvc, roomRepo, userRepo)
+	srv.SetupRoutes()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.app.Listener(ln) }()
+	// Closing the listener rather than shutting the server down: fasthttp's
+	// Shutdown resets state that the still running socket handlers read.
+	t.Cleanup(func() {
+		cancel()
+		_ = ln.Close()
+	})
+	return &testNode{id: nodeID, base: "http://" + ln.Addr().String() + "/video-conference", wsSvc: wsSvc, halt: cancel}
+}
+
+type testUser struct {
+	id, token string
+}
+
+func (n *testNode) register(t *testing.T, name string) testUser {
+	t.Helper()
+	res := n.request(t, testUser{}, http.MethodPost, "/auth/register", map[string]string{
+		"userName": name,
+		"email":    name + "@example.com",
+		"password": "password",
+	})
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("register %s: %s", name, res.Status)
+	}
+	var u testUser
+	for _, c := range res.Cookies() {
+		switch c.Name {
+		case "access_token":
+			u.token = c.Value
+		case "videoConferenceUserId":
+			u.id = c.Value
+		}
+	}
+	return u
+}
+
+func (n *testNode) request(t *testing.T, u testUser, method, path string, body any) *http.Response {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req, err := http.NewRequest(method, n.base+path, bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if u.token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.token)
+		req.AddCookie(&http.Cookie{Name: "videoConferenceUserId", Value: u.id})
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// call sends a request and decodes the response, whatever its status.
+func (n *testNode) call(t *testing.T, u testUser, method, path string, body any) (int, map[string]any) {
+	t.Helper()
+	res := n.request(t, u, method, path, body)
+	defer res.Body.Close()
+	var out map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return res.StatusCode, out
+}
+
+func (n *testNode) createRoom(t *testing.T, owner testUser) string {
+	t.Helper()
+	status, out := n.call(t, owner, http.MethodPost, "/room/", map[string]string{"title": "standup"})
+	if status != http.StatusOK {
+		t.Fatalf("create room: %d %v", status, out)
+	}
+	return out["message"].(map[string]any)["id"].(string)
+}
+
+type testSocket struct {
+	conn *fastws.Conn
+}
+
+// dial opens a JSON socket to roomID as u. query is appended to the URL.
+func (n *testNode) dial(t *testing.T, u testUser, roomID string, query ...string) *testSocket {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(n.base, "http") + "/ws/" + roomID + "?access_token=" + u.token
+	for _, q := range query {
+		url += "&" + q
+	}
+	dialer := fastws.Dialer{Subprotocols: []string{"vc.v2"}, HandshakeTimeout: 2 * time.Second}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", roomID, err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testSocket{conn: conn}
+}
+
+func (s *testSocket) send(t *testing.T, msg any) {
+	t.Helper()
+	if err := s.conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// await reads until a message of one of the given types arrives and
+// returns it.
+func (s *testSocket) await(t *testing.T, types ...string) map[string]any {
+	t.Helper()
+	_ = s.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		var msg map[string]any
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for %v: %v", types, err)
+		}
+		if slices.Contains(types, msg["type"].(string)) {
+			return msg
+		}
+		if msg["type"] == protocol.TypeError {
+			t.Fatalf("waiting for %v: %v", types, msg)
+		}
+	}
+}
+
+func TestDrainHoldsClientThatClosedEarly(t *testing.T) {
+	tc := newTestCluster(t)
+	tc.resumeGrace = 300 * time.Millisecond
+	a, b := tc.start(t, "node-a"), tc.start(t, "node-b")
+	owner := a.register(t, "owner")
+	mover := a.register(t, "mover")
+	roomID := a.createRoom(t, owner)
+
+	watcher := b.dial(t, owner, roomID)
+	watcher.await(t, protocol.TypeSession)
+	moving := a.dial(t, mover, roomID)
+	moving.await(t, protocol.TypeSession)
+	watcher.await(t, protocol.TypeUserJoined)
+
+	drained := make(chan struct{})
+	go func() {
+		a.wsSvc.Drain(context.Background(), 2*time.Second)
+		close(drained)
+	}()
+	moving.await(t, protocol.TypeServerDraining)
+	// The client drops its old socket before reconnecting anywhere.
+	_ = moving.conn.Close()
+
+	if msg := watcher.await(t, protocol.TypeUserReconnecting, protocol.TypeUserLeft); msg["type"] != protocol.TypeUserReconnecting {
+		t.Fatalf("got %v, want %s", msg["type"], protocol.TypeUserReconnecting)
+	}
+	// It never comes back, so it leaves once the resume window is over.
+	watcher.await(t, protocol.TypeUserLeft)
+	select {
+	case <-drained:
+	case <-time.After(3 * time.Second):
+		t.Fatal("drain did not finish")
+	}
+}
@@ -36,6 +36,7 @@ func (s *AuthService) Register(ctx context.Context, username string, email strin
 		ImgUrl:       "https://via.placeholder.com/150",
 		Email:        email,
 		HashPassword: hash,
+		OrgID:        &models.DefaultOrgID,
 	}
 	if err = s.userRepo.CreateUser(ctx, user); err != nil {
 		return "", "", "", err
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"video-conference/models"
+	"video-conference/protocol"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxBreakouts             = 50
+	defaultBreakoutCountdown = 60 * time.Second
+	maxBreakoutCountdown     = 10 * time.Minute
+)
+
+var errNoBreakouts = errors.New("no open breakout rooms")
+
+// breakoutParent returns the room whose breakouts c may manage.
+func (s *WebSocketService) breakoutParent(ctx context.Context, c *client) (*models.Room, error) {
+	if !s.RoleOf(c.roomID, c.userID).CanModerate() {
+		return nil, errForbidden
+	}
+	parent, err := s.roomRepo.GetRoom(ctx, c.roomID)
+	if err != nil {
+		return nil, err
+	}
+	if parent.ParentID != nil {
+		return nil, protocol.Errorf(protocol.CodeNotAllowed, "breakouts cannot be nested")
+	}
+	return parent, nil
+}
+
+func (s *WebSocketService) createBreakouts(ctx context.Context, c *client, m *protocol.CreateBreakouts) error {
+	if m.Count > maxBreakouts {
+		return protocol.Errorf(protocol.CodeBadRequest, "count must be between 1 and %d", maxBreakouts)
+	}
+	parent, err := s.breakoutParent(ctx, c)
+	if err != nil {
+		return err
+	}
+	count := m.Count
+	existing, err := s.roomRepo.ListChildRooms(ctx, parent.ID.String())
+	if err != nil {
+		return err
+	}
+
+	rooms := make([]protocol.BreakoutRoom, 0, len(existing)+count)
+	for _, r := range existing {
+		rooms = append(rooms, protocol.BreakoutRoom{ID: r.ID.String(), Title: r.Title})
+	}
+	for i := 1; i <= count; i++ {
+		child := &models.Room{
+			ID:              uuid.New(),
+			OwnerID:         parent.OwnerID,
+			OrgID:           parent.OrgID,
+			Shared:          parent.Shared,
+			ParentID:        &parent.ID,
+			Title:           fmt.Sprintf("%s – Room %d", parent.Title, len(existing)+i),
+			Description:     parent.Description,
+			MaxParticipants: parent.MaxParticipants,
+			IsActive:        true,
+			CreatedAt:       time.Now(),
+			UpdatedAt:       time.Now(),
+		}
+		if err := s.roomRepo.CreateRoom(ctx, child); err != nil {
+			return err
+		}
+		rooms = append(rooms, protocol.BreakoutRoom{ID: child.ID.String(), Title: child.Title})
+	}
+
+	return s.roomRepo.PublishMessage(ctx, parent.ID.String(), protocol.BreakoutsCreated{
+		Envelope: protocol.Envelope{Type: protocol.TypeBreakoutsCreated, Actor: c.userID},
+		Rooms:    rooms,
+	})
+}
+
+func (s *WebSocketService) assignBreakouts(ctx context.Context, c *client, m *protocol.AssignBreakouts) error {
+	parent, err := s.breakoutParent(ctx, c)
+	if err != nil {
+		return err
+	}
+	if m.Random {
+		return s.assignRandomly(ctx, parent)
+	}
+	children, err := s.roomRepo.ListChildRooms(ctx, parent.ID.String())
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*models.Room, len(children))
+	for i := range children {
+		byID[children[i].ID.String()] = &children[i]
+	}
+
+	for userID, childID := range m.Assignments {
+		child, ok := byID[childID]
+		if !ok {
+			return protocol.Errorf(protocol.CodeBadRequest, "unknown breakout room %q", childID)
+		}
+		s.sendBreakoutAssignment(ctx, parent, child, userID)
+	}
+	return nil
+}
+
+func (s *WebSocketService) assignRandomly(ctx context.Context, parent *models.Room) error {
+	children, err := s.roomRepo.ListChildRooms(ctx, parent.ID.String())
+	if err != nil {
+		return err
+	}
+	if len(children) == 0 {
+		return errNoBreakouts
+	}
+	ids, err := s.roomRepo.GetParticipants(ctx, parent.ID.String())
+	if err != nil {
+		return err
+	}
+
+	var pool []string
+	for _, id := range ids {
+		if !s.RoleOf(parent.ID.String(), id).CanModerate() {
+			pool = append(pool, id)
+		}
+	}
+	rand.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+
+	for i, userID := range pool {
+		s.sendBreakoutAssignment(ctx, parent, &children[i%len(children)], userID)
+	}
+	return nil
+}
+
+func (s *WebSocketService) sendBreakoutAssignment(ctx context.Context, parent, child *models.Room, userID string) {
+	_, err := s.roomRepo.PublishToUser(ctx, userID, protocol.BreakoutAssigned{
+		Envelope: protocol.Envelope{Type: protocol.TypeBreakoutAssigned, Target: userID},
+		ParentID: parent.ID.String(),
+		RoomID:   child.ID.String(),
+		Title:    child.Title,
+	})
+	if err != nil {
+		log.Printf("[ROOM %s] breakout assignment for %s failed: %v", parent.ID, userID, err)
+	}
+}
+
+// closeBreakouts warns every breakout room, then after countdown tells their
+// clients to return to the parent and closes the child rooms.
+func (s *WebSocketService) closeBreakouts(ctx context.Context, c *client, m *protocol.CloseBreakouts) error {
+	parent, err := s.breakoutParent(ctx, c)
+	if err != nil {
+		return err
+	}
+	countdown := defaultBreakoutCountdown
+	if m.Countdown != nil {
+		countdown = min(time.Duration(*m.Countdown)*time.Second, maxBreakoutCountdown)
+	}
+	children, err := s.roomRepo.ListChildRooms(ctx, parent.ID.String())
+	if err != nil {
+		return err
+	}
+	if len(children) == 0 {
+		return errNoBreakouts
+	}
+
+	closing := protocol.BreakoutsClosing{
+		Envelope: protocol.Envelope{Type: protocol.TypeBreakoutsClosing, Actor: c.userID},
+		ParentID: parent.ID.String(),
+		Seconds:  int(countdown / time.Second),
+	}
+	closed := protocol.BreakoutsClosed{Envelope: protocol.Event(protocol.TypeBreakoutsClosed), ParentID: parent.ID.String()}
+	_ = s.roomRepo.PublishMessage(ctx, parent.ID.String(), closing)
+	for _, child := range children {
+		_ = s.roomRepo.PublishMessage(ctx, child.ID.String(), closing)
+	}
+
+	time.AfterFunc(countdown, func() {
+		bg := context.Background()
+		for _, child := range children {
+			childID := child.ID.String()
+			_ = s.roomRepo.PublishMessage(bg, childID, closed)
+			if err := s.roomRepo.DeactivateRoom(bg, childID); err != nil {
+				log.Printf("[ROOM %s] closing breakout failed: %v", childID, err)
+			}
+			_ = s.roomRepo.ClearRoomState(bg, childID)
+		}
+		_ = s.roomRepo.PublishMessage(bg, parent.ID.String(), closed)
+	})
+	return nil
+}
+
+func (s *WebSocketService) broadcastBreakouts(ctx context.Context, c *client, m *protocol.BroadcastBreakouts) error {
+	parent, err := s.breakoutParent(ctx, c)
+	if err != nil {
+		return err
+	}
+	children, err := s.roomRepo.ListChildRooms(ctx, parent.ID.String())
+	if err != nil {
+		return err
+	}
+	if len(children) == 0 {
+		return errNoBreakouts
+	}
+
+	msg := protocol.BreakoutBroadcast{
+		Envelope: protocol.Envelope{Type: protocol.TypeBreakoutBroadcast, Actor: c.userID},
+		ParentID: parent.ID.String(),
+		Text:     m.Text,
+	}
+	for _, child := range children {
+		if err := s.roomRepo.PublishMessage(ctx, child.ID.String(), msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
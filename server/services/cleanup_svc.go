@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"video-conference/models"
+	"video-conference/protocol"
+	"video-conference/repositories"
+)
+
+var (
+	cleanupRuns          = expvar.NewInt("cleanup_runs")
+	cleanupIdleClosed    = expvar.NewInt("cleanup_idle_rooms_closed")
+	cleanupExpiredClosed = expvar.NewInt("cleanup_expired_rooms_closed")
+	cleanupStalePurged   = expvar.NewInt("cleanup_stale_participants_purged")
+	cleanupOrphansPurged = expvar.NewInt("cleanup_orphan_room_states_purged")
+	cleanupOpenClosed    = expvar.NewInt("cleanup_open_participations_closed")
+)
+
+type CleanupService struct {
+	roomRepo *repositories.RoomRepository
+	wsSvc    *WebSocketService
+
+	interval    time.Duration
+	idleGrace   time.Duration
+	maxLifetime time.Duration
+}
+
+func NewCleanupService(
+	roomRepo *repositories.RoomRepository,
+	wsSvc *WebSocketService,
+	interval time.Duration,
+	idleGrace time.Duration,
+	maxLifetime time.Duration,
+) *CleanupService {
+	return &CleanupService{
+		roomRepo:    roomRepo,
+		wsSvc:       wsSvc,
+		interval:    interval,
+		idleGrace:   idleGrace,
+		maxLifetime: maxLifetime,
+	}
+}
+
+func (s *CleanupService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.wsSvc.RefreshPresence(ctx)
+		if ok, err := s.roomRepo.AcquireLock(ctx, "room-cleanup", s.interval/2); err == nil && ok {
+			s.sweep(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *CleanupService) sweep(ctx context.Context) {
+	cleanupRuns.Add(1)
+	now := time.Now()
+
+	stale := s.purgeStaleParticipants(ctx, now.Add(-3*s.interval))
+	s.closeOpenParticipations(ctx, now.Add(-3*s.interval))
+
+	rooms, err := s.roomRepo.ListActiveRooms(ctx)
+	if err != nil {
+		log.Printf("cleanup: list rooms: %v", err)
+		return
+	}
+
+	var idle, expired int
+	for i := range rooms {
+		switch s.inspect(ctx, &rooms[i], now) {
+		case "idle":
+			idle++
+		case "expired":
+			expired++
+		}
+	}
+
+	if stale+idle+expired > 0 {
+		log.Printf("cleanup: purged %d stale participants, closed %d idle and %d expired rooms", stale, idle, expired)
+	}
+}
+
+func (s *CleanupService) purgeStaleParticipants(ctx context.Context, before time.Time) int {
+	ids, err := s.roomRepo.RoomIDsWithState(ctx)
+	if err != nil {
+		log.Printf("cleanup: scan participants: %v", err)
+		return 0
+	}
+
+	total := 0
+	for _, roomID := range ids {
+		if room, err := s.roomRepo.GetRoom(ctx, roomID); err != nil || !room.IsActive {
+			if err := s.wsSvc.ResetRoom(ctx, roomID); err == nil {
+				cleanupOrphansPurged.Add(1)
+			}
+			continue
+		}
+
+		purged, err := s.roomRepo.PurgeStaleParticipants(ctx, roomID, before)
+		if err != nil {
+			log.Printf("[ROOM %s] cleanup: purge participants: %v", roomID, err)
+			continue
+		}
+		for _, userID := range purged {
+			_ = s.roomRepo.PublishMessage(ctx, roomID, protocol.Presence{
+				Envelope: protocol.Envelope{Type: protocol.TypeUserLeft, Sender: userID},
+				UserInfo: protocol.UserInfo{UserID: userID},
+			})
+		}
+		total += len(purged)
+	}
+	cleanupStalePurged.Add(int64(total))
+	return total
+}
+
+// closeOpenParticipations ends participation records left open by sockets
+// that died with their node, so usage minutes stop accruing.
+func (s *CleanupService) closeOpenParticipations(ctx context.Context, joinedBefore time.Time) {
+	open, err := s.roomRepo.ListOpenParticipations(ctx, joinedBefore)
+	if err != nil {
+		log.Printf("cleanup: list participations: %v", err)
+		return
+	}
+	for _, p := range open {
+		if present, err := s.roomRepo.IsParticipant(ctx, p.RoomID.String(), p.UserID.String()); err != nil || present {
+			continue
+		}
+		if err := s.roomRepo.RecordLeave(ctx, p.ID); err == nil {
+			cleanupOpenClosed.Add(1)
+		}
+	}
+}
+
+// inspect closes a room if it outlived its maximum lifetime or has been
+// empty for longer than the grace period. Scheduled and permanent rooms are
+// only reset when idle, since they are expected to be reused.
+func (s *CleanupService) inspect(ctx context.Context, room *models.Room, now time.Time) string {
+	roomID := room.ID.String()
+	adHoc := room.StartsAt == nil && !room.Permanent
+
+	if adHoc && now.Sub(room.CreatedAt) > s.maxLifetime {
+		if err := s.wsSvc.EndMeeting(ctx, roomID, ""); err != nil {
+			log.Printf("[ROOM %s] cleanup: expire: %v", roomID, err)
+			return ""
+		}
+		cleanupExpiredClosed.Add(1)
+		return "expired"
+	}
+
+	n, err := s.roomRepo.CountParticipants(ctx, roomID)
+	if err != nil {
+		return ""
+	}
+	if n > 0 {
+		if room.EmptySince != nil {
+			_ = s.roomRepo.SetEmptySince(ctx, roomID, nil)
+		}
+		return ""
+	}
+	if room.EmptySince == nil {
+		_ = s.roomRepo.SetEmptySince(ctx, roomID, &now)
+		return ""
+	}
+	if now.Sub(*room.EmptySince) < s.idleGrace {
+		return ""
+	}
+
+	finished := room.EndsAt != nil && room.RRule == "" && room.EndsAt.Before(now)
+	if !adHoc && !finished {
+		// Reusable rooms keep EmptySince, so only reset their state once.
+		if now.Sub(*room.EmptySince) >= s.idleGrace+s.interval {
+			return ""
+		}
+		_ = s.wsSvc.ResetRoom(ctx, roomID)
+		return "idle"
+	}
+
+	if err := s.wsSvc.EndMeeting(ctx, roomID, ""); err != nil {
+		log.Printf("[ROOM %s] cleanup: close idle: %v", roomID, err)
+		return ""
+	}
+	_ = s.roomRepo.SetEmptySince(ctx, roomID, nil)
+	cleanupIdleClosed.Add(1)
+	return "idle"
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"video-conference/models"
+	"video-conference/protocol"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	sendBuffer = 256
+	// maxDropped is how many droppable messages in a row a client may miss
+	// before it is treated as stalled and disconnected.
+	maxDropped = 64
+)
+
+// droppable events may be discarded for a client that has fallen behind;
+// losing one never leaves the client in a wrong state.
+var droppable = map[string]bool{
+	protocol.TypeChat:              true,
+	protocol.TypeHandRaised:        true,
+	protocol.TypeHandLowered:       true,
+	protocol.TypeBreakoutBroadcast: true,
+	protocol.TypeLobbyRequest:      true,
+	protocol.TypeLobbyResolved:     true,
+}
+
+type outbound struct {
+	kind int
+	data []byte
+}
+
+// client owns one socket. Every write goes through its queue and is
+// performed by a single writer goroutine, since the underlying connection
+// does not allow concurrent writers.
+type client struct {
+	conn     *websocket.Conn
+	connID   string
+	room     *models.Room
+	roomID   string
+	userID   string
+	user     *models.User
+	version  int
+	encoding protocol.Encoding
+	limiter  *limiter
+
+	pingInterval time.Duration
+	writeTimeout time.Duration
+
+	out      chan outbound
+	quit     chan struct{}
+	finished chan struct{}
+	stopOnce sync.Once
+	dropped  atomic.Int32
+	evicted  atomic.Bool
+}
+
+func newClient(conn *websocket.Conn, userID string, pingInterval, writeTimeout time.Duration) *client {
+	c := &client{
+		conn:         conn,
+		connID:       uuid.NewString(),
+		userID:       userID,
+		pingInterval: pingInterval,
+		writeTimeout: writeTimeout,
+		out:          make(chan outbound, sendBuffer),
+		quit:         make(chan struct{}),
+		finished:     make(chan struct{}),
+	}
+	go c.writePump()
+	return c
+}
+
+// send queues msg; it is never dropped for being slow.
+func (c *client) send(msg any) {
+	data, err := protocol.Marshal(c.encoding, msg)
+	if err != nil {
+		log.Printf("[ROOM %s] encoding message for %s: %v", c.roomID, c.userID, err)
+		return
+	}
+	c.enqueue(outbound{kind: c.frameKind(), data: data}, false)
+}
+
+// sendRaw queues an event of the given type already in the client's
+// encoding.
+func (c *client) sendRaw(typ string, data []byte) {
+	c.enqueue(outbound{kind: c.frameKind(), data: data}, droppable[typ])
+}
+
+func (c *client) frameKind() int {
+	if c.encoding == protocol.MsgPack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// close queues a close frame behind everything already sent, so the client
+// still learns why it is being disconnected.
+func (c *client) close(code int, reason string) {
+	c.enqueue(outbound{kind: websocket.CloseMessage, data: websocket.FormatCloseMessage(code, reason)}, false)
+}
+
+func (c *client) enqueue(m outbound, canDrop bool) {
+	select {
+	case <-c.quit:
+		return
+	default:
+	}
+	select {
+	case c.out <- m:
+		c.dropped.Store(0)
+	default:
+		if canDrop && c.dropped.Add(1) <= maxDropped {
+			return
+		}
+		c.evict()
+	}
+}
+
+// evict disconnects a client whose queue overflowed. The close frame skips
+// the queue, which is full by definition.
+func (c *client) evict() {
+	if !c.evicted.CompareAndSwap(false, true) {
+		return
+	}
+	log.Printf("[ROOM %s] disconnecting slow client %s", c.roomID, c.userID)
+	msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow")
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(c.writeTimeout))
+	_ = c.conn.Close()
+}
+
+// writePump also pings the peer so its pongs keep the read deadline alive.
+func (c *client) writePump() {
+	defer close(c.finished)
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !c.write(outbound{kind: websocket.PingMessage}) {
+				return
+			}
+		case m := <-c.out:
+			if !c.write(m) {
+				return
+			}
+		case <-c.quit:
+			for {
+				select {
+				case m := <-c.out:
+					if !c.write(m) {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *client) write(m outbound) bool {
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	if err := c.conn.WriteMessage(m.kind, m.data); err != nil || m.kind == websocket.CloseMessage {
+		_ = c.conn.Close()
+		return false
+	}
+	return true
+}
+
+// stop flushes what is already queued and ends the writer.
+func (c *client) stop() {
+	c.stopOnce.Do(func() { close(c.quit) })
+	<-c.finished
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"video-conference/models"
+	"video-conference/protocol"
+)
+
+type handlerFunc func(ctx context.Context, c *client, raw []byte) error
+
+// on decodes and validates a request before handing it to fn.
+func on[T any, PT interface {
+	*T
+	protocol.Validator
+}](fn func(context.Context, *client, PT) error) handlerFunc {
+	return func(ctx context.Context, c *client, raw []byte) error {
+		msg := PT(new(T))
+		if err := protocol.Unmarshal(raw, msg); err != nil {
+			return protocol.Errorf(protocol.CodeBadRequest, "malformed message: %v", err)
+		}
+		if err := msg.Validate(); err != nil {
+			return err
+		}
+		return fn(ctx, c, msg)
+	}
+}
+
+func (s *WebSocketService) requestHandlers() map[string]handlerFunc {
+	h := map[string]handlerFunc{
+		protocol.TypeHello:              on(s.hello),
+		protocol.TypeHeartbeat:          on(s.heartbeat),
+		protocol.TypeChat:               on(s.chat),
+		protocol.TypeOffer:              on(s.forwardSignal),
+		protocol.TypeAnswer:             on(s.forwardSignal),
+		protocol.TypeICECandidate:       on(s.forwardSignal),
+		protocol.TypeLockRoom:           on(s.lockRoom),
+		protocol.TypeEndMeeting:         on(s.endMeeting),
+		protocol.TypeCreateBreakouts:    on(s.createBreakouts),
+		protocol.TypeAssignBreakouts:    on(s.assignBreakouts),
+		protocol.TypeCloseBreakouts:     on(s.closeBreakouts),
+		protocol.TypeBroadcastBreakouts: on(s.broadcastBreakouts),
+		protocol.TypeAdmit:              on(s.resolveLobby),
+		protocol.TypeDeny:               on(s.resolveLobby),
+	}
+	for action := range settingsEvents {
+		h[action] = on(s.useFeature)
+	}
+	for action := range moderationEvents {
+		h[action] = on(s.moderate)
+	}
+	return h
+}
+
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{errForbidden, protocol.CodeForbidden},
+	{errInvalidTarget, protocol.CodeInvalidTarget},
+	{errInvalidRole, protocol.CodeInvalidRole},
+	{errRoomFull, protocol.CodeRoomFull},
+	{errChatDisabled, protocol.CodeNotAllowed},
+	{errScreenShareDenied, protocol.CodeNotAllowed},
+	{errRecordingDenied, protocol.CodeNotAllowed},
+	{errNoBreakouts, protocol.CodeConflict},
+	{ErrRoomNotFound, protocol.CodeRoomNotFound},
+	{ErrQuotaExceeded, protocol.CodeQuotaExceeded},
+}
+
+func asProtocolError(err error) *protocol.Error {
+	var perr *protocol.Error
+	if errors.As(err, &perr) {
+		return perr
+	}
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return protocol.Errorf(e.code, "%s", err.Error())
+		}
+	}
+	return protocol.Errorf(protocol.CodeInternal, "internal error")
+}
+
+// dispatch runs one client request. V1 clients never hear about frames the
+// server could not parse or route, matching the original protocol. It only
+// fails when c has to be disconnected.
+func (s *WebSocketService) dispatch(ctx context.Context, c *client, raw []byte) error {
+	var req protocol.Request
+	err := protocol.Unmarshal(raw, &req)
+	if ok, err := s.admit(ctx, c, req); !ok {
+		return err
+	}
+	if err != nil || req.Type == "" {
+		if c.version >= protocol.V2 {
+			c.send(protocol.Errorf(protocol.CodeBadRequest, "message must be an object with a type").Reply(req))
+		}
+		return nil
+	}
+	handle, ok := s.handlers[req.Type]
+	if !ok {
+		if c.version >= protocol.V2 {
+			c.send(protocol.Errorf(protocol.CodeUnknownType, "unknown message type %q", req.Type).Reply(req))
+		}
+		return nil
+	}
+
+	if err := handle(ctx, c, raw); err != nil {
+		perr := asProtocolError(err)
+		if perr.Code == protocol.CodeInternal {
+			log.Printf("[ROOM %s] %s from %s failed: %v", c.roomID, req.Type, c.userID, err)
+		}
+		c.send(perr.Reply(req))
+		return nil
+	}
+	if c.version >= protocol.V2 && req.RequestID != "" {
+		c.send(protocol.Ack{Type: protocol.TypeAck, Action: req.Type, RequestID: req.RequestID})
+	}
+	return nil
+}
+
+func (s *WebSocketService) hello(_ context.Context, c *client, m *protocol.Hello) error {
+	if !protocol.Supported(m.Version) {
+		return protocol.Errorf(protocol.CodeUnsupportedVersion, "protocol version %d is not supported", m.Version)
+	}
+	c.version = m.Version
+	c.send(welcome(c))
+	return nil
+}
+
+func (s *WebSocketService) heartbeat(_ context.Context, c *client, _ *protocol.Request) error {
+	c.send(protocol.HeartbeatAck{Envelope: protocol.Event(protocol.TypeHeartbeatAck), Time: time.Now().UnixMilli()})
+	return nil
+}
+
+func welcome(c *client) protocol.Welcome {
+	return protocol.Welcome{
+		Envelope:  protocol.Event(protocol.TypeWelcome),
+		Version:   c.version,
+		Supported: []int{protocol.V1, protocol.V2},
+		Encoding:  c.encoding.String(),
+	}
+}
+
+// roomEvent is the part of a fanned-out event the socket loop routes on.
+type roomEvent struct {
+	protocol.Envelope
+	Role     models.RoomRole `json:"role"`
+	ParentID string          `json:"parentID"`
+	RoomID   string          `json:"roomID"`
+	ConnID   string          `json:"connID"`
+	ToConn   string          `json:"toConn"`
+}
+
+// signalTypes reach their target over its user channel when it is connected
+// to another node, so they carry the room they belong to.
+var signalTypes = map[string]bool{
+	protocol.TypeOffer:        true,
+	protocol.TypeAnswer:       true,
+	protocol.TypeICECandidate: true,
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	"video-conference/protocol"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+// drainSpread is the window over which draining clients are told to
+// reconnect, so they do not all hit the remaining nodes at once.
+const drainSpread = 5 * time.Second
+
+// Draining a node moves its clients elsewhere before it shuts down. New
+// joins are refused and every client is told to reconnect to another node
+// with its resume token, which is held for it in the meantime. A client
+// that closes before another node has taken over is held for the resume
+// window like any dropped connection, and the drain waits for that window
+// to run out. Clients still here when the timeout runs out are closed and
+// leave their rooms.
+
+func (s *WebSocketService) Draining() bool {
+	return s.draining.Load()
+}
+
+// Connections counts the sockets open on this node.
+func (s *WebSocketService) Connections() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	n := 0
+	for _, roomMap := range s.connections {
+		for _, userMap := range roomMap {
+			n += len(userMap)
+		}
+	}
+	return n
+}
+
+func (s *WebSocketService) Drain(ctx context.Context, timeout time.Duration) {
+	if !s.draining.CompareAndSwap(false, true) {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	s.mutex.Lock()
+	s.drainBy = deadline
+	s.mutex.Unlock()
+	close(s.drain)
+	log.Printf("node %s: draining %d connections", s.nodeID, s.Connections())
+
+	if !s.waitForConnections(ctx, deadline) {
+		s.mutex.RLock()
+		var left []*client
+		for _, roomMap := range s.connections {
+			for _, userMap := range roomMap {
+				for _, c := range userMap {
+					left = append(left, c)
+				}
+			}
+		}
+		s.mutex.RUnlock()
+
+		log.Printf("node %s: closing %d connections that did not move", s.nodeID, len(left))
+		for _, c := range left {
+			c.close(websocket.CloseServiceRestart, "server shutting down")
+		}
+		s.waitForConnections(ctx, time.Now().Add(2*s.writeTimeout))
+	}
+	s.waitForHolds(ctx)
+
+	// Whatever is still tracked for this node is stale from here on.
+	s.reap(ctx, s.nodeID)
+}
+
+// waitForConnections reports whether every socket closed before deadline.
+func (s *WebSocketService) waitForConnections(ctx context.Context, deadline time.Time) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.Connections() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// waitForHolds lets the resume windows of sessions dropped here run out, so
+// that this node, not the final reap, decides whether their users left.
+func (s *WebSocketService) waitForHolds(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.holds.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	case <-time.After(s.resumeGrace + s.writeTimeout):
+	}
+}
+
+func (s *WebSocketService) drainDeadline() time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.drainBy
+}
+
+func (s *WebSocketService) drainNotice() protocol.ServerDraining {
+	return protocol.ServerDraining{
+		Envelope:   protocol.Event(protocol.TypeServerDraining),
+		RetryAfter: rand.Intn(int(drainSpread / time.Millisecond)),
+		Deadline:   max(int(time.Until(s.drainDeadline())/time.Second), 0),
+	}
+}
+
+// offerMigration holds c's session under its resume token, so that another
+// node can take it over, and tells the client to go there. It reports
+// whether the session is held.
+func (s *WebSocketService) offerMigration(ctx context.Context, c *client, token string, pid uuid.UUID) bool {
+	defer c.send(s.drainNotice())
+	if s.resumeGrace <= 0 {
+		return false
+	}
+	ttl := time.Until(s.drainDeadline()) + s.resumeGrace
+	if err := s.roomRepo.HoldResume(ctx, token, s.resumeState(c, pid), ttl); err != nil {
+		log.Printf("[ROOM %s] holding session of %s for migration failed: %v", c.roomID, c.userID, err)
+		return false
+	}
+	return true
+}
+
+// migrated reports whether the session of a client closing during a drain
+// lives on: taken over by another node, or held for the resume window when
+// the client closed before reconnecting elsewhere.
+func (s *WebSocketService) migrated(ctx context.Context, c *client, token string, pid uuid.UUID) bool {
+	state, err := s.roomRepo.ClaimResume(ctx, token)
+	if err != nil {
+		return false
+	}
+	if state != nil {
+		return s.holdForResume(ctx, c, token, pid)
+	}
+	// Resumed on another node, which tracks the user now.
+	if s.otherConns(c.roomID, c.userID, c.connID) == 0 {
+		s.handedOver(ctx, c.roomID, c.user, c.connID, s.RoleOf(c.roomID, c.userID), pid)
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"log"
+	"strconv"
+	"sync"
+
+	"video-conference/protocol"
+)
+
+// encodedBacklog is how many room events the node keeps re-encoded for its
+// clients; it only has to outlast the fan-out of one event.
+const encodedBacklog = 1024
+
+type encodedEvent struct {
+	src  string
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// encodedCache re-encodes each room event at most once per encoding, however
+// many local clients receive it. Events are keyed by room and sequence
+// number, which every subscriber on the node sees alike; since a reset room
+// numbers its events afresh, an entry only counts if its source matches.
+type encodedCache struct {
+	mu      sync.Mutex
+	entries map[string]*encodedEvent
+	order   []string
+	next    int
+}
+
+func newEncodedCache() *encodedCache {
+	return &encodedCache{
+		entries: make(map[string]*encodedEvent),
+		order:   make([]string, encodedBacklog),
+	}
+}
+
+func (ec *encodedCache) entry(key string, raw []byte) *encodedEvent {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	if e, ok := ec.entries[key]; ok {
+		if e.src == string(raw) {
+			return e
+		}
+		e = &encodedEvent{src: string(raw)}
+		ec.entries[key] = e
+		return e
+	}
+	if old := ec.order[ec.next]; old != "" {
+		delete(ec.entries, old)
+	}
+	e := &encodedEvent{src: string(raw)}
+	ec.entries[key] = e
+	ec.order[ec.next] = key
+	ec.next = (ec.next + 1) % len(ec.order)
+	return e
+}
+
+// encodedFor returns raw in c's encoding. Numbered room events are
+// re-encoded once and shared; anything else is re-encoded on the spot.
+func (s *WebSocketService) encodedFor(c *client, ev roomEvent, raw []byte) ([]byte, bool) {
+	if protocol.Detect(raw) == c.encoding {
+		return raw, true
+	}
+	var e *encodedEvent
+	if ev.Seq != 0 {
+		key := c.roomID + "/" + strconv.FormatInt(ev.Seq, 10) + "/" + c.encoding.String()
+		e = s.encoded.entry(key, raw)
+	} else {
+		e = &encodedEvent{}
+	}
+	e.once.Do(func() { e.data, e.err = protocol.Transcode(raw, c.encoding) })
+	if e.err != nil {
+		log.Printf("[ROOM %s] re-encoding %s: %v", c.roomID, ev.Type, e.err)
+		return nil, false
+	}
+	return e.data, true
+}
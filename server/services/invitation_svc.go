@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+
+	"video-conference/mailer"
+	"video-conference/models"
+	"video-conference/protocol"
+	"video-conference/repositories"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvalidInvitee     = errors.New("invalid invitee")
+)
+
+type InvitationService struct {
+	invRepo  *repositories.InvitationRepository
+	roomRepo *repositories.RoomRepository
+	userRepo *repositories.UserRepository
+	mailer   mailer.Mailer
+	appURL   string
+}
+
+func NewInvitationService(
+	invRepo *repositories.InvitationRepository,
+	roomRepo *repositories.RoomRepository,
+	userRepo *repositories.UserRepository,
+	m mailer.Mailer,
+	appURL string,
+) *InvitationService {
+	return &InvitationService{
+		invRepo:  invRepo,
+		roomRepo: roomRepo,
+		userRepo: userRepo,
+		mailer:   m,
+		appURL:   strings.TrimRight(appURL, "/"),
+	}
+}
+
+// Invite accepts a mix of user IDs and email addresses. Registered users are
+// linked by ID so the invite follows them if they change address.
+func (s *InvitationService) Invite(ctx context.Context, inviterID uuid.UUID, roomID string, invitees []string) ([]models.Invitation, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil || !room.IsActive {
+		return nil, ErrRoomNotFound
+	}
+	if !room.IsManager(inviterID.String()) {
+		return nil, ErrNotRoomOwner
+	}
+	inviter, _ := s.userRepo.GetUserByID(ctx, inviterID.String())
+
+	out := make([]models.Invitation, 0, len(invitees))
+	for _, raw := range invitees {
+		inv, invitee, err := s.resolve(ctx, strings.TrimSpace(raw))
+		if err != nil {
+			return out, err
+		}
+		inv.RoomID = room.ID
+		inv.InviterID = inviterID
+		inv.Status = models.InvitationPending
+		if err := s.invRepo.CreateInvitation(ctx, inv); err != nil {
+			return out, err
+		}
+		inv.Room = *room
+		s.deliver(ctx, inv, invitee, inviter)
+		out = append(out, *inv)
+	}
+	return out, nil
+}
+
+func (s *InvitationService) resolve(ctx context.Context, raw string) (*models.Invitation, *models.User, error) {
+	if id, err := uuid.Parse(raw); err == nil {
+		u, err := s.userRepo.GetUserByID(ctx, id.String())
+		if err != nil || u == nil {
+			return nil, nil, ErrInvalidInvitee
+		}
+		return &models.Invitation{InviteeID: &u.ID, Email: u.Email}, u, nil
+	}
+
+	addr, err := mail.ParseAddress(raw)
+	if err != nil {
+		return nil, nil, ErrInvalidInvitee
+	}
+	email := strings.ToLower(addr.Address)
+	if u, _ := s.userRepo.GetUserByEmail(ctx, email); u != nil {
+		return &models.Invitation{InviteeID: &u.ID, Email: email}, u, nil
+	}
+	return &models.Invitation{Email: email}, nil, nil
+}
+
+// deliver pushes the invite over the invitee's open sockets and falls back to
+// email when no socket on any node received it.
+func (s *InvitationService) deliver(ctx context.Context, inv *models.Invitation, invitee *models.User, inviter *models.User) {
+	inviterName := "Someone"
+	if inviter != nil {
+		inviterName = inviter.UserName
+	}
+
+	if invitee != nil {
+		n, err := s.roomRepo.PublishToUser(ctx, invitee.ID.String(), protocol.Invitation{
+			Envelope: protocol.Event(protocol.TypeInvitation),
+			ID:       inv.ID.String(),
+			RoomID:   inv.RoomID.String(),
+			Title:    inv.Room.Title,
+			From:     inviterName,
+		})
+		if err == nil && n > 0 {
+			return
+		}
+	}
+	if inv.Email == "" {
+		return
+	}
+
+	msg := mailer.Message{
+		To:      []string{inv.Email},
+		Subject: fmt.Sprintf("%s invited you to %s", inviterName, inv.Room.Title),
+		Body: fmt.Sprintf("%s invited you to join \"%s\".\n\n%s\n\nJoin: %s/videoWindow?room=%s\n",
+			inviterName, inv.Room.Title, inv.Room.Description, s.appURL, inv.RoomID),
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("[ROOM %s] invitation mail failed: %v", inv.RoomID, err)
+	}
+}
+
+func (s *InvitationService) Pending(ctx context.Context, userID string) ([]models.Invitation, error) {
+	u, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil || u == nil {
+		return nil, errors.New("user not found")
+	}
+	return s.invRepo.ListPendingForUser(ctx, userID, u.Email)
+}
+
+func (s *InvitationService) Respond(ctx context.Context, userID, invitationID string, accept bool) (*models.Invitation, error) {
+	inv, err := s.invRepo.GetInvitation(ctx, invitationID)
+	if err != nil || inv == nil || !s.addressedTo(ctx, inv, userID) {
+		return nil, ErrInvitationNotFound
+	}
+
+	status := models.InvitationDeclined
+	if accept {
+		status = models.InvitationAccepted
+	}
+	if err := s.invRepo.SetStatus(ctx, invitationID, status); err != nil {
+		return nil, err
+	}
+	inv.Status = status
+
+	_ = s.roomRepo.PublishMessage(ctx, inv.RoomID.String(), protocol.Invitation{
+		Envelope: protocol.Event(protocol.TypeInvitation + "-" + string(status)),
+		ID:       inv.ID.String(),
+		UserID:   userID,
+	})
+	return inv, nil
+}
+
+func (s *InvitationService) CanJoin(ctx context.Context, room *models.Room, userID string) bool {
+	if !room.InviteOnly || room.IsManager(userID) {
+		return true
+	}
+	email := ""
+	if u, _ := s.userRepo.GetUserByID(ctx, userID); u != nil {
+		email = u.Email
+	}
+	ok, _ := s.invRepo.IsInvited(ctx, room.ID.String(), userID, email)
+	return ok
+}
+
+func (s *InvitationService) addressedTo(ctx context.Context, inv *models.Invitation, userID string) bool {
+	if inv.InviteeID != nil && inv.InviteeID.String() == userID {
+		return true
+	}
+	u, _ := s.userRepo.GetUserByID(ctx, userID)
+	return u != nil && inv.Email != "" && strings.EqualFold(u.Email, inv.Email)
+}
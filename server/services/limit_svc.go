@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"time"
+
+	"video-conference/models"
+	"video-conference/protocol"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+var (
+	messagesLimited   = expvar.NewInt("ws_messages_rate_limited")
+	clientsDisconnect = expvar.NewInt("ws_clients_disconnected_for_abuse")
+)
+
+const (
+	// strikeGap is the shortest time between two strikes, so a single
+	// flood earns one strike per second rather than one per message.
+	strikeGap = time.Second
+	// strikeReset forgives earlier strikes after this long without one.
+	strikeReset = time.Minute
+)
+
+// errAbuse ends a connection that kept flooding the room or sent a frame
+// over the size limit.
+var errAbuse = errors.New("client disconnected for abuse")
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter meters one connection with token buckets: one shared by all its
+// messages and one per message type that has a limit of its own. It is only
+// used by the connection's reader goroutine.
+type limiter struct {
+	limits  map[string]models.RateLimit
+	buckets map[string]*bucket
+	strikes int
+	struck  time.Time
+}
+
+func newLimiter(limits map[string]models.RateLimit) *limiter {
+	return &limiter{limits: limits, buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token for a message of type typ, returning how long until
+// one is available if there is none.
+func (l *limiter) allow(typ string, now time.Time) (bool, time.Duration) {
+	keys := []string{models.AnyMessage, typ}
+	if _, ok := l.limits[typ]; !ok || typ == models.AnyMessage {
+		keys = keys[:1]
+	}
+	wait := time.Duration(0)
+	for _, k := range keys {
+		if w := l.refill(k, now); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, k := range keys {
+		if b := l.buckets[k]; b != nil {
+			b.tokens--
+		}
+	}
+	return true, 0
+}
+
+// refill tops up key's bucket and returns zero if it holds a token.
+func (l *limiter) refill(key string, now time.Time) time.Duration {
+	lim, ok := l.limits[key]
+	if !ok || lim.Rate <= 0 {
+		return 0
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(lim.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(lim.Burst), b.tokens+now.Sub(b.last).Seconds()*lim.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / lim.Rate * float64(time.Second))
+}
+
+// strike records a rejected message and reports whether it earned a new
+// strike, which the client is warned about.
+func (l *limiter) strike(now time.Time) bool {
+	if now.Sub(l.struck) < strikeGap {
+		return false
+	}
+	if now.Sub(l.struck) > strikeReset {
+		l.strikes = 0
+	}
+	l.strikes++
+	l.struck = now
+	return true
+}
+
+// admit applies c's rate limits to a message of type typ. It returns
+// errAbuse once the client has run out of strikes.
+func (s *WebSocketService) admit(ctx context.Context, c *client, req protocol.Request) (bool, error) {
+	now := time.Now()
+	ok, wait := c.limiter.allow(req.Type, now)
+	if ok {
+		return true, nil
+	}
+	messagesLimited.Add(1)
+	if !c.limiter.strike(now) {
+		return false, nil
+	}
+	if s.maxStrikes > 0 && c.limiter.strikes > s.maxStrikes {
+		c.close(websocket.ClosePolicyViolation, "rate limit exceeded")
+		s.audit(ctx, c, models.AuditRateLimited, fmt.Sprintf("%d rate limit strikes, last on %q", c.limiter.strikes, req.Type))
+		return false, errAbuse
+	}
+	c.send(protocol.RateLimited{
+		Envelope:   protocol.Event(protocol.TypeRateLimited),
+		Action:     req.Type,
+		RequestID:  req.RequestID,
+		RetryAfter: int(wait.Milliseconds()) + 1,
+		Strikes:    c.limiter.strikes,
+		MaxStrikes: s.maxStrikes,
+	})
+	return false, nil
+}
+
+// audit records why c is being disconnected.
+func (s *WebSocketService) audit(ctx context.Context, c *client, action, detail string) {
+	clientsDisconnect.Add(1)
+	log.Printf("[ROOM %s] disconnecting %s: %s", c.roomID, c.userID, detail)
+
+	rec := &models.AuditRecord{Action: action, NodeID: s.nodeID, Detail: detail}
+	rec.UserID, _ = uuid.Parse(c.userID)
+	if roomID, err := uuid.Parse(c.roomID); err == nil {
+		rec.RoomID = &roomID
+	}
+	if err := s.audits.Record(ctx, rec); err != nil {
+		log.Printf("[ROOM %s] audit record for %s failed: %v", c.roomID, c.userID, err)
+	}
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"video-conference/models"
+	"video-conference/protocol"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+var (
+	errForbidden     = errors.New("forbidden")
+	errInvalidTarget = errors.New("invalid target")
+	errInvalidRole   = errors.New("invalid role")
+	errRoomFull      = errors.New("room full")
+)
+
+var moderationEvents = map[string]string{
+	protocol.TypeKick:             protocol.TypeKicked,
+	protocol.TypeBan:              protocol.TypeBanned,
+	protocol.TypeMuteRequest:      protocol.TypeMuteRequested,
+	protocol.TypeStopVideoRequest: protocol.TypeVideoStopRequested,
+	protocol.TypeRaiseHand:        protocol.TypeHandRaised,
+	protocol.TypeLowerHand:        protocol.TypeHandLowered,
+	protocol.TypePromote:          protocol.TypeRoleChanged,
+	protocol.TypeDemote:           protocol.TypeRoleChanged,
+}
+
+func (s *WebSocketService) RoleOf(roomID, userID string) models.RoomRole {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if role, ok := s.roles[roomID][userID]; ok {
+		return role
+	}
+	return models.RoleParticipant
+}
+
+// setRole assigns a role, recording it in Redis so that every node can count
+// the room's panelists.
+func (s *WebSocketService) setRole(ctx context.Context, roomID, userID string, role models.RoomRole) {
+	s.learnRole(roomID, userID, role)
+	if err := s.roomRepo.SetRole(ctx, roomID, userID, role); err != nil {
+		log.Printf("[ROOM %s] recording role of %s failed: %v", roomID, userID, err)
+	}
+}
+
+// learnRole keeps a role assigned on another node.
+func (s *WebSocketService) learnRole(roomID, userID string, role models.RoomRole) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	roles, ok := s.roles[roomID]
+	if !ok {
+		roles = make(map[string]models.RoomRole)
+		s.roles[roomID] = roles
+	}
+	roles[userID] = role
+}
+
+func (s *WebSocketService) assignRole(ctx context.Context, room *models.Room, userID string) models.RoomRole {
+	roomID := room.ID.String()
+	role := models.RoleParticipant
+	if room.Type == models.RoomWebinar {
+		role = models.RoleViewer
+	}
+	switch {
+	case room.OwnerID.String() == userID:
+		role = models.RoleHost
+	case room.IsCoOwner(userID):
+		role = models.RoleCoHost
+		if present, _ := s.roomRepo.IsParticipant(ctx, roomID, room.OwnerID.String()); !present {
+			role = models.RoleHost
+		}
+	}
+
+	s.mutex.RLock()
+	prev, ok := s.roles[roomID][userID]
+	s.mutex.RUnlock()
+	if ok && prev.Rank() > role.Rank() {
+		role = prev
+	}
+
+	s.setRole(ctx, roomID, userID, role)
+	return role
+}
+
+func (s *WebSocketService) moderate(ctx context.Context, c *client, m *protocol.Moderate) error {
+	roomID, actorID, action := c.roomID, c.userID, m.Type
+	target := m.Target
+	if target == "" {
+		target = actorID
+	}
+
+	actor := s.RoleOf(roomID, actorID)
+	current := s.RoleOf(roomID, target)
+	self := target == actorID
+	outranks := actor.CanModerate() && !self && actor.Rank() > current.Rank()
+
+	event := protocol.Moderation{Envelope: protocol.Envelope{Type: moderationEvents[action], Actor: actorID, Target: target}}
+
+	switch action {
+	case protocol.TypeRaiseHand:
+		if !self {
+			return errForbidden
+		}
+		s.reports.Count(ctx, roomID, statHands)
+	case protocol.TypeLowerHand:
+		if !self && !actor.CanModerate() {
+			return errForbidden
+		}
+	case protocol.TypeKick, protocol.TypeBan, protocol.TypeMuteRequest, protocol.TypeStopVideoRequest:
+		if !outranks {
+			return errForbidden
+		}
+		if action == protocol.TypeBan {
+			if err := s.roomRepo.BanUser(ctx, roomID, target); err != nil {
+				return err
+			}
+		}
+	case protocol.TypePromote, protocol.TypeDemote:
+		if !outranks {
+			return errForbidden
+		}
+		next := m.Role
+		if action == protocol.TypeDemote && next == "" {
+			next = models.RoleParticipant
+			if current == models.RoleParticipant {
+				next = models.RoleViewer
+			}
+		}
+		if !next.Valid() {
+			return errInvalidRole
+		}
+		if (action == protocol.TypePromote && next.Rank() <= current.Rank()) ||
+			(action == protocol.TypeDemote && next.Rank() >= current.Rank()) {
+			return errInvalidRole
+		}
+		if next.Rank() > actor.Rank() {
+			return errForbidden
+		}
+		if next.IsPanelist() && !current.IsPanelist() && !s.hasPanelistSeat(ctx, roomID) {
+			return errRoomFull
+		}
+
+		s.setRole(ctx, roomID, target, next)
+		event.Role = next
+
+		if next == models.RoleHost {
+			s.setRole(ctx, roomID, actorID, models.RoleCoHost)
+			handoff := roleChanged(actorID, actorID, models.RoleCoHost)
+			if err := s.roomRepo.PublishMessage(ctx, roomID, handoff); err != nil {
+				return err
+			}
+		}
+	default:
+		return errForbidden
+	}
+
+	return s.roomRepo.PublishMessage(ctx, roomID, event)
+}
+
+// handOffHost promotes the owner, or else the longest-standing co-owner, still
+// in the room when the host drops out, so the meeting is never left without one.
+func (s *WebSocketService) handOffHost(ctx context.Context, roomID, leaverID string) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil || !room.IsActive {
+		return
+	}
+	candidates := []string{room.OwnerID.String()}
+	for _, c := range room.CoOwners {
+		candidates = append(candidates, c.UserID.String())
+	}
+
+	for _, id := range candidates {
+		if id == leaverID {
+			continue
+		}
+		if present, _ := s.roomRepo.IsParticipant(ctx, roomID, id); !present {
+			continue
+		}
+		s.setRole(ctx, roomID, id, models.RoleHost)
+		_ = s.roomRepo.PublishMessage(ctx, roomID, roleChanged(leaverID, id, models.RoleHost))
+		return
+	}
+}
+
+func roleChanged(actorID, target string, role models.RoomRole) protocol.Moderation {
+	return protocol.Moderation{
+		Envelope: protocol.Envelope{Type: protocol.TypeRoleChanged, Actor: actorID, Target: target},
+		Role:     role,
+	}
+}
+
+func (s *WebSocketService) lockRoom(ctx context.Context, c *client, m *protocol.LockRoom) error {
+	if s.RoleOf(c.roomID, c.userID) != models.RoleHost {
+		return errForbidden
+	}
+	if err := s.roomRepo.SetLocked(ctx, c.roomID, m.Locked); err != nil {
+		return err
+	}
+	return s.roomRepo.PublishMessage(ctx, c.roomID, protocol.RoomLocked{
+		Envelope: protocol.Envelope{Type: protocol.TypeRoomLocked, Actor: c.userID},
+		Locked:   m.Locked,
+	})
+}
+
+func (s *WebSocketService) endMeeting(ctx context.Context, c *client, _ *protocol.Request) error {
+	if s.RoleOf(c.roomID, c.userID) != models.RoleHost {
+		return errForbidden
+	}
+	return s.EndMeeting(ctx, c.roomID, c.userID)
+}
+
+func (s *WebSocketService) EndMeeting(ctx context.Context, roomID, actorID string) error {
+	ended := protocol.Envelope{Type: protocol.TypeMeetingEnded, Actor: actorID}
+	if err := s.roomRepo.PublishMessage(ctx, roomID, ended); err != nil {
+		return err
+	}
+	if err := s.roomRepo.DeactivateRoom(ctx, roomID); err != nil {
+		return err
+	}
+	return s.ResetRoom(ctx, roomID)
+}
+
+// ResetRoom finishes the room's running meeting and drops its live state.
+func (s *WebSocketService) ResetRoom(ctx context.Context, roomID string) error {
+	s.reports.Finish(ctx, roomID)
+	return s.roomRepo.ClearRoomState(ctx, roomID)
+}
+
+func (s *WebSocketService) applyRoomEvent(roomID, userID string, ev roomEvent, raw []byte) (closeCode int, reason string) {
+	switch ev.Type {
+	case protocol.TypeUserJoined, protocol.TypeUserReconnected:
+		// Roles are assigned on the joining user's node; learn them here too.
+		if ev.Role.Valid() {
+			s.learnRole(roomID, ev.Sender, ev.Role)
+		}
+	case protocol.TypeRoleChanged:
+		if ev.Role.Valid() {
+			s.learnRole(roomID, ev.Target, ev.Role)
+		}
+	case protocol.TypeSettingsUpdated:
+		var msg protocol.Settings
+		if protocol.Unmarshal(raw, &msg) == nil {
+			s.setSettings(roomID, msg.Settings)
+		}
+	case protocol.TypeKicked, protocol.TypeBanned:
+		if ev.Target == userID {
+			return websocket.ClosePolicyViolation, "removed by host"
+		}
+	case protocol.TypeMeetingEnded:
+		return websocket.CloseNormalClosure, "meeting ended"
+	case protocol.TypeBreakoutsClosed:
+		if ev.ParentID != roomID {
+			return websocket.CloseNormalClosure, "returning to main room"
+		}
+	}
+	return 0, ""
+}
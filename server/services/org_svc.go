@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"video-conference/models"
+	"video-conference/repositories"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrOrgNotFound    = errors.New("organization not found")
+	ErrInvalidOrg     = errors.New("invalid organization")
+	ErrNotOrgMember   = errors.New("not an organization member")
+	ErrNotOrgAdmin    = errors.New("not an organization admin")
+	ErrInvalidOrgRole = errors.New("invalid organization role")
+	ErrLastOrgOwner   = errors.New("organization needs an owner")
+)
+
+type OrgService struct {
+	orgRepo  *repositories.OrgRepository
+	userRepo *repositories.UserRepository
+}
+
+func NewOrgService(orgRepo *repositories.OrgRepository, userRepo *repositories.UserRepository) *OrgService {
+	return &OrgService{orgRepo: orgRepo, userRepo: userRepo}
+}
+
+type OrgInput struct {
+	Name            string          `json:"name"`
+	RoomDefaults    json.RawMessage `json:"roomDefaults"`
+	MaxParticipants *int            `json:"maxParticipants"`
+}
+
+func (s *OrgService) Create(ctx context.Context, userID uuid.UUID, name string) (*models.Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return nil, ErrInvalidOrg
+	}
+	org := &models.Organization{
+		ID:           uuid.New(),
+		Name:         name,
+		RoomDefaults: models.DefaultRoomSettings(),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := s.orgRepo.CreateOrg(ctx, org, userID); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+func (s *OrgService) List(ctx context.Context, userID string) ([]models.OrgMembership, error) {
+	return s.orgRepo.ListForUser(ctx, userID)
+}
+
+func (s *OrgService) Get(ctx context.Context, userID, orgID string) (*models.Organization, *models.OrgMembership, error) {
+	org, err := s.orgRepo.GetOrg(ctx, orgID)
+	if err != nil || org == nil {
+		return nil, nil, ErrOrgNotFound
+	}
+	m, err := s.orgRepo.GetMembership(ctx, orgID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m == nil {
+		return nil, nil, ErrNotOrgMember
+	}
+	return org, m, nil
+}
+
+func (s *OrgService) Update(ctx context.Context, actorID, orgID string, in OrgInput) (*models.Organization, error) {
+	org, m, err := s.Get(ctx, actorID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Role.CanAdmin() {
+		return nil, ErrNotOrgAdmin
+	}
+
+	if name := strings.TrimSpace(in.Name); name != "" {
+		if len(name) > 100 {
+			return nil, ErrInvalidOrg
+		}
+		org.Name = name
+	}
+	if in.MaxParticipants != nil {
+		if *in.MaxParticipants < 0 || *in.MaxParticipants > models.MaxRoomParticipants {
+			return nil, ErrInvalidOrg
+		}
+		org.MaxParticipants = *in.MaxParticipants
+	}
+	defaults, err := overlaySettings(org.DefaultSettings(), in.RoomDefaults)
+	if err != nil {
+		return nil, err
+	}
+	if err := org.CheckLimits(defaults); err != nil {
+		return nil, err
+	}
+	org.RoomDefaults = defaults
+
+	if err := s.orgRepo.UpdateOrg(ctx, org); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+func (s *OrgService) Members(ctx context.Context, actorID, orgID string) ([]models.OrgMembership, error) {
+	if _, _, err := s.Get(ctx, actorID, orgID); err != nil {
+		return nil, err
+	}
+	return s.orgRepo.ListMembers(ctx, orgID)
+}
+
+// SetMember adds a user by ID or email, or changes their role. Only owners
+// may hand out or take away the owner role.
+func (s *OrgService) SetMember(ctx context.Context, actorID, orgID, user string, role models.OrgRole) error {
+	org, actor, err := s.Get(ctx, actorID, orgID)
+	if err != nil {
+		return err
+	}
+	if !actor.Role.CanAdmin() {
+		return ErrNotOrgAdmin
+	}
+	if role == "" {
+		role = models.OrgMember
+	}
+	if !role.Valid() {
+		return ErrInvalidOrgRole
+	}
+	u := findUser(ctx, s.userRepo, user)
+	if u == nil {
+		return ErrInvalidOrg
+	}
+
+	current, err := s.orgRepo.GetMembership(ctx, orgID, u.ID.String())
+	if err != nil {
+		return err
+	}
+	wasOwner := current != nil && current.Role == models.OrgOwner
+	if (role == models.OrgOwner || wasOwner) && actor.Role != models.OrgOwner {
+		return ErrNotOrgAdmin
+	}
+	if wasOwner && role != models.OrgOwner {
+		if err := s.keepOwner(ctx, orgID); err != nil {
+			return err
+		}
+	}
+	return s.orgRepo.SetMember(ctx, org.ID, u.ID, role)
+}
+
+// RemoveMember lets admins remove others and anyone leave on their own.
+func (s *OrgService) RemoveMember(ctx context.Context, actorID, orgID, userID string) error {
+	org, actor, err := s.Get(ctx, actorID, orgID)
+	if err != nil {
+		return err
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return ErrNotOrgMember
+	}
+	target, err := s.orgRepo.GetMembership(ctx, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if target == nil {
+		return ErrNotOrgMember
+	}
+
+	if actorID != userID {
+		if !actor.Role.CanAdmin() || (target.Role == models.OrgOwner && actor.Role != models.OrgOwner) {
+			return ErrNotOrgAdmin
+		}
+	}
+	if target.Role == models.OrgOwner {
+		if err := s.keepOwner(ctx, orgID); err != nil {
+			return err
+		}
+	}
+	return s.orgRepo.RemoveMember(ctx, org.ID, uid)
+}
+
+func (s *OrgService) keepOwner(ctx context.Context, orgID string) error {
+	n, err := s.orgRepo.CountOwners(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if n <= 1 {
+		return ErrLastOrgOwner
+	}
+	return nil
+}
+
+func (s *OrgService) SetHome(ctx context.Context, userID, orgID string) (*models.Organization, error) {
+	org, _, err := s.Get(ctx, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.orgRepo.SetHomeOrg(ctx, userID, org.ID); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// HomeOrg resolves the organization new rooms go into: orgRef when given,
+// else the user's home organization. Users without one are enrolled in the
+// default organization.
+func (s *OrgService) HomeOrg(ctx context.Context, userID, orgRef string) (*models.Organization, error) {
+	if orgRef != "" {
+		org, _, err := s.Get(ctx, userID, orgRef)
+		return org, err
+	}
+
+	u, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil || u == nil {
+		return nil, ErrInvalidOrg
+	}
+	if u.OrgID != nil {
+		if org, _, err := s.Get(ctx, userID, u.OrgID.String()); err == nil {
+			return org, nil
+		}
+	}
+
+	org, err := s.orgRepo.GetOrg(ctx, models.DefaultOrgID.String())
+	if err != nil || org == nil {
+		return nil, ErrOrgNotFound
+	}
+	if m, _ := s.orgRepo.GetMembership(ctx, org.ID.String(), userID); m == nil {
+		if err := s.orgRepo.SetMember(ctx, org.ID, u.ID, models.OrgMember); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.orgRepo.SetHomeOrg(ctx, userID, org.ID); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+func (s *OrgService) RoomOrg(ctx context.Context, room *models.Room) *models.Organization {
+	if room.OrgID == nil {
+		return nil
+	}
+	org, _ := s.orgRepo.GetOrg(ctx, room.OrgID.String())
+	return org
+}
+
+// CanAccess reports whether userID may see and join room: rooms are private
+// to their organization unless explicitly shared.
+func (s *OrgService) CanAccess(ctx context.Context, room *models.Room, userID string) bool {
+	if room.OrgID == nil || room.Shared || room.IsManager(userID) {
+		return true
+	}
+	m, _ := s.orgRepo.GetMembership(ctx, room.OrgID.String(), userID)
+	return m != nil
+}
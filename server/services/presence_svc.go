@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"time"
+
+	"video-conference/protocol"
+	"video-conference/repositories"
+)
+
+var presenceReaped = expvar.NewInt("presence_entries_reaped")
+
+// Every node records the sockets it holds under its own ID and keeps that
+// ID alive with a heartbeat. When a node stops heartbeating, the survivors
+// take its entries out of their rooms. IDs are unique per process unless
+// NODE_ID pins one, in which case a restarted node clears what its previous
+// run left behind, and nothing else.
+
+// Reclaim drops presence left by this node's previous run. Call it before
+// accepting connections.
+func (s *WebSocketService) Reclaim(ctx context.Context) {
+	s.reap(ctx, s.nodeID)
+}
+
+func (s *WebSocketService) RunHeartbeat(ctx context.Context, interval, ttl time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.beat(ctx, ttl)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// beat keeps this node alive for ttl and reaps the nodes that are not.
+func (s *WebSocketService) beat(ctx context.Context, ttl time.Duration) {
+	if err := s.roomRepo.BeatNode(ctx, s.nodeID, ttl); err != nil {
+		log.Printf("node %s: heartbeat failed: %v", s.nodeID, err)
+	}
+	if dead, err := s.roomRepo.DeadNodes(ctx); err == nil {
+		for _, nodeID := range dead {
+			if nodeID != s.nodeID {
+				s.reap(ctx, nodeID)
+			}
+		}
+	}
+}
+
+// reap removes the users nodeID held from their rooms, unless another node
+// holds a socket for them as well.
+func (s *WebSocketService) reap(ctx context.Context, nodeID string) {
+	entries, err := s.roomRepo.TakeNodePresence(ctx, nodeID)
+	if err != nil {
+		log.Printf("node %s: reaping presence failed: %v", nodeID, err)
+		return
+	}
+
+	n := 0
+	for _, e := range entries {
+		if tracked, err := s.roomRepo.IsTracked(ctx, e); err != nil || tracked {
+			continue
+		}
+		if err := s.roomRepo.RemoveParticipant(ctx, e.RoomID, e.UserID); err != nil {
+			continue
+		}
+		_ = s.roomRepo.PublishMessage(ctx, e.RoomID, departed(e))
+		n++
+	}
+	if n > 0 {
+		presenceReaped.Add(int64(n))
+		log.Printf("node %s: reaped %d participants", nodeID, n)
+	}
+}
+
+func departed(e repositories.PresenceEntry) protocol.Presence {
+	return protocol.Presence{
+		Envelope: protocol.Envelope{Type: protocol.TypeUserLeft, Sender: e.UserID},
+		UserInfo: protocol.UserInfo{UserID: e.UserID},
+	}
+}
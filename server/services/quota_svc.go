@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"video-conference/models"
+	"video-conference/repositories"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+type QuotaService struct {
+	roomRepo *repositories.RoomRepository
+	userRepo *repositories.UserRepository
+	orgRepo  *repositories.OrgRepository
+
+	plans       map[string]models.Plan
+	defaultPlan string
+}
+
+func NewQuotaService(
+	roomRepo *repositories.RoomRepository,
+	userRepo *repositories.UserRepository,
+	orgRepo *repositories.OrgRepository,
+	plans map[string]models.Plan,
+	defaultPlan string,
+) *QuotaService {
+	return &QuotaService{
+		roomRepo:    roomRepo,
+		userRepo:    userRepo,
+		orgRepo:     orgRepo,
+		plans:       plans,
+		defaultPlan: defaultPlan,
+	}
+}
+
+type UsageReport struct {
+	Plan  models.Plan  `json:"plan"`
+	Usage models.Usage `json:"usage"`
+}
+
+func (s *QuotaService) UserUsage(ctx context.Context, userID string) (*UsageReport, error) {
+	u, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil || u == nil {
+		return nil, errors.New("user not found")
+	}
+	usage, err := s.usage(ctx, repositories.ByOwner, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &UsageReport{Plan: s.userPlan(u), Usage: usage}, nil
+}
+
+func (s *QuotaService) OrgUsage(ctx context.Context, actorID, orgID string) (*UsageReport, error) {
+	org, err := s.orgRepo.GetOrg(ctx, orgID)
+	if err != nil || org == nil {
+		return nil, ErrOrgNotFound
+	}
+	if m, _ := s.orgRepo.GetMembership(ctx, orgID, actorID); m == nil {
+		return nil, ErrNotOrgMember
+	}
+	usage, err := s.usage(ctx, repositories.ByOrg, orgID)
+	if err != nil {
+		return nil, err
+	}
+	plan, _ := s.orgPlan(org)
+	return &UsageReport{Plan: plan, Usage: usage}, nil
+}
+
+// CheckCreate enforces the monthly room allowance of the owner and of the
+// organization the room is created in.
+func (s *QuotaService) CheckCreate(ctx context.Context, ownerID string, org *models.Organization) error {
+	since := periodStart(time.Now())
+	for _, sc := range s.scopes(ctx, ownerID, org) {
+		if sc.plan.RoomsPerMonth == 0 {
+			continue
+		}
+		n, err := s.roomRepo.CountRoomsCreated(ctx, sc.by, sc.id, since)
+		if err != nil {
+			return err
+		}
+		if n >= int64(sc.plan.RoomsPerMonth) {
+			return fmt.Errorf("%w: rooms per month", ErrQuotaExceeded)
+		}
+	}
+	return nil
+}
+
+// CheckJoin enforces concurrency and minute limits of the room's owner and
+// organization before userID is let in. Users already connected to the room,
+// e.g. from another device, are not checked again.
+func (s *QuotaService) CheckJoin(ctx context.Context, room *models.Room, userID string) error {
+	roomID := room.ID.String()
+	if present, _ := s.roomRepo.IsTracked(ctx, repositories.PresenceEntry{RoomID: roomID, UserID: userID}); present {
+		return nil
+	}
+
+	var org *models.Organization
+	if room.OrgID != nil {
+		org, _ = s.orgRepo.GetOrg(ctx, room.OrgID.String())
+	}
+	for _, sc := range s.scopes(ctx, room.OwnerID.String(), org) {
+		p := sc.plan
+		if p.ConcurrentRooms > 0 || p.ConcurrentParticipants > 0 {
+			rooms, participants, err := s.live(ctx, sc.by, sc.id)
+			if err != nil {
+				return err
+			}
+			if p.ConcurrentParticipants > 0 && participants >= p.ConcurrentParticipants {
+				return fmt.Errorf("%w: concurrent participants", ErrQuotaExceeded)
+			}
+			if p.ConcurrentRooms > 0 && !rooms[roomID] && len(rooms) >= p.ConcurrentRooms {
+				return fmt.Errorf("%w: concurrent rooms", ErrQuotaExceeded)
+			}
+		}
+		if p.MinutesPerMonth > 0 {
+			used, err := s.roomRepo.ParticipantMinutes(ctx, sc.by, sc.id, periodStart(time.Now()))
+			if err != nil {
+				return err
+			}
+			if used >= int64(p.MinutesPerMonth) {
+				return fmt.Errorf("%w: meeting minutes", ErrQuotaExceeded)
+			}
+		}
+	}
+	return nil
+}
+
+type quotaScope struct {
+	by, id string
+	plan   models.Plan
+}
+
+func (s *QuotaService) scopes(ctx context.Context, ownerID string, org *models.Organization) []quotaScope {
+	var out []quotaScope
+	if u, _ := s.userRepo.GetUserByID(ctx, ownerID); u != nil {
+		out = append(out, quotaScope{repositories.ByOwner, ownerID, s.userPlan(u)})
+	}
+	if org != nil {
+		if plan, ok := s.orgPlan(org); ok {
+			out = append(out, quotaScope{repositories.ByOrg, org.ID.String(), plan})
+		}
+	}
+	return out
+}
+
+func (s *QuotaService) usage(ctx context.Context, by, id string) (models.Usage, error) {
+	u := models.Usage{PeriodStart: periodStart(time.Now())}
+	var err error
+	if u.RoomsCreated, err = s.roomRepo.CountRoomsCreated(ctx, by, id, u.PeriodStart); err != nil {
+		return u, err
+	}
+	rooms, participants, err := s.live(ctx, by, id)
+	if err != nil {
+		return u, err
+	}
+	u.ConcurrentRooms, u.ConcurrentParticipants = len(rooms), participants
+	u.Minutes, err = s.roomRepo.ParticipantMinutes(ctx, by, id, u.PeriodStart)
+	return u, err
+}
+
+// live counts rooms in scope that currently have someone in them. Open
+// participations are only candidates; presence decides.
+func (s *QuotaService) live(ctx context.Context, by, id string) (map[string]bool, int, error) {
+	ids, err := s.roomRepo.LiveRoomIDs(ctx, by, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	rooms := make(map[string]bool, len(ids))
+	total := 0
+	for _, roomID := range ids {
+		users, err := s.roomRepo.PresentUsers(ctx, roomID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(users) > 0 {
+			rooms[roomID] = true
+			total += len(users)
+		}
+	}
+	return rooms, total, nil
+}
+
+func (s *QuotaService) userPlan(u *models.User) models.Plan {
+	if p, ok := s.plans[u.Plan]; ok {
+		return p
+	}
+	return s.plans[s.defaultPlan]
+}
+
+func (s *QuotaService) orgPlan(org *models.Organization) (models.Plan, bool) {
+	p, ok := s.plans[org.Plan]
+	return p, ok
+}
+
+func periodStart(t time.Time) time.Time {
+	y, m, _ := t.UTC().Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+}
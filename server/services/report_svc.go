@@ -0,0 +1,321 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"video-conference/mailer"
+	"video-conference/models"
+	"video-conference/repositories"
+
+	"github.com/google/uuid"
+)
+
+// Counters kept in Redis while a meeting runs.
+const (
+	statChat  = "chat"
+	statHands = "hands"
+	statPolls = "polls"
+)
+
+var ErrMeetingNotFound = errors.New("meeting session not found")
+
+type ReportService struct {
+	roomRepo    *repositories.RoomRepository
+	userRepo    *repositories.UserRepository
+	meetingRepo *repositories.MeetingRepository
+	mailer      mailer.Mailer
+}
+
+func NewReportService(
+	roomRepo *repositories.RoomRepository,
+	userRepo *repositories.UserRepository,
+	meetingRepo *repositories.MeetingRepository,
+	m mailer.Mailer,
+) *ReportService {
+	return &ReportService{roomRepo: roomRepo, userRepo: userRepo, meetingRepo: meetingRepo, mailer: m}
+}
+
+type Interval struct {
+	JoinedAt time.Time  `json:"joinedAt"`
+	LeftAt   *time.Time `json:"leftAt,omitempty"`
+}
+
+type AttendeeReport struct {
+	UserID    uuid.UUID  `json:"userId"`
+	UserName  string     `json:"userName"`
+	Email     string     `json:"email"`
+	Intervals []Interval `json:"intervals"`
+	Seconds   int64      `json:"seconds"`
+}
+
+type Report struct {
+	SessionID        uuid.UUID        `json:"sessionId"`
+	RoomID           uuid.UUID        `json:"roomId"`
+	Title            string           `json:"title"`
+	StartedAt        time.Time        `json:"startedAt"`
+	EndedAt          *time.Time       `json:"endedAt,omitempty"`
+	PeakParticipants int              `json:"peakParticipants"`
+	ChatMessages     int              `json:"chatMessages"`
+	HandsRaised      int              `json:"handsRaised"`
+	PollsRun         int              `json:"pollsRun"`
+	Participants     []AttendeeReport `json:"participants"`
+}
+
+// Begin returns the meeting running in room, starting one if the room is
+// idle.
+func (s *ReportService) Begin(ctx context.Context, room *models.Room) *uuid.UUID {
+	roomID := room.ID.String()
+	for i := 0; i < 2; i++ {
+		if id, err := s.roomRepo.CurrentMeeting(ctx, roomID); err != nil {
+			return nil
+		} else if id != "" {
+			parsed, err := uuid.Parse(id)
+			if err != nil {
+				return nil
+			}
+			return &parsed
+		}
+
+		m := &models.MeetingSession{ID: uuid.New(), RoomID: room.ID, StartedAt: time.Now()}
+		if ok, err := s.roomRepo.StartMeeting(ctx, roomID, m.ID.String()); err != nil {
+			return nil
+		} else if !ok {
+			continue
+		}
+		if err := s.meetingRepo.CreateMeeting(ctx, m); err != nil {
+			log.Printf("[ROOM %s] create meeting session: %v", roomID, err)
+			return nil
+		}
+		return &m.ID
+	}
+	return nil
+}
+
+func (s *ReportService) Count(ctx context.Context, roomID, stat string) {
+	_ = s.roomRepo.CountMeetingEvent(ctx, roomID, stat)
+}
+
+// Finish closes the meeting running in roomID, if any, and stores its
+// summary. It is safe to call from several nodes at once.
+func (s *ReportService) Finish(ctx context.Context, roomID string) {
+	id, stats, err := s.roomRepo.TakeMeeting(ctx, roomID)
+	if err != nil || id == "" {
+		return
+	}
+	m, err := s.meetingRepo.GetMeeting(ctx, id)
+	if err != nil || m == nil {
+		return
+	}
+
+	now := time.Now()
+	if err := s.meetingRepo.CloseParticipations(ctx, id, now); err != nil {
+		log.Printf("[ROOM %s] close participations: %v", roomID, err)
+	}
+	parts, err := s.meetingRepo.ListParticipations(ctx, id)
+	if err != nil {
+		log.Printf("[ROOM %s] list participations: %v", roomID, err)
+	}
+
+	m.EndedAt = &now
+	m.PeakParticipants = peakConcurrency(parts, now)
+	m.ChatMessages, _ = strconv.Atoi(stats[statChat])
+	m.HandsRaised, _ = strconv.Atoi(stats[statHands])
+	m.PollsRun, _ = strconv.Atoi(stats[statPolls])
+	if err := s.meetingRepo.SaveMeeting(ctx, m); err != nil {
+		log.Printf("[ROOM %s] save meeting session: %v", roomID, err)
+		return
+	}
+
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err == nil && room.Settings.EmailReport {
+		go s.mailReport(context.Background(), room, buildReport(room, m, parts, now))
+	}
+}
+
+func (s *ReportService) Sessions(ctx context.Context, actorID uuid.UUID, roomID string) ([]models.MeetingSession, error) {
+	if _, err := s.managed(ctx, actorID, roomID); err != nil {
+		return nil, err
+	}
+	return s.meetingRepo.ListMeetings(ctx, roomID, 100)
+}
+
+func (s *ReportService) Report(ctx context.Context, actorID uuid.UUID, roomID, sessionID string) (*Report, error) {
+	room, err := s.managed(ctx, actorID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.meetingRepo.GetMeeting(ctx, sessionID)
+	if err != nil || m == nil || m.RoomID != room.ID {
+		return nil, ErrMeetingNotFound
+	}
+	parts, err := s.meetingRepo.ListParticipations(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	r := buildReport(room, m, parts, time.Now())
+	if m.EndedAt == nil {
+		r.PeakParticipants = peakConcurrency(parts, time.Now())
+	}
+	return r, nil
+}
+
+func (s *ReportService) managed(ctx context.Context, actorID uuid.UUID, roomID string) (*models.Room, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, ErrRoomNotFound
+	}
+	if !room.IsManager(actorID.String()) {
+		return nil, ErrNotRoomOwner
+	}
+	return room, nil
+}
+
+func (s *ReportService) mailReport(ctx context.Context, room *models.Room, r *Report) {
+	owner, _ := s.userRepo.GetUserByID(ctx, room.OwnerID.String())
+	if owner == nil || owner.Email == "" {
+		return
+	}
+	data, err := r.CSV()
+	if err != nil {
+		return
+	}
+
+	msg := mailer.Message{
+		To:      []string{owner.Email},
+		Subject: "Meeting report: " + room.Title,
+		Body: fmt.Sprintf("Your meeting %q ran from %s to %s with %d participants (peak %d).\n",
+			room.Title, r.StartedAt.Format(time.RFC1123), r.EndedAt.Format(time.RFC1123),
+			len(r.Participants), r.PeakParticipants),
+		Attachments: []mailer.Attachment{{
+			Filename:    "meeting-report.csv",
+			ContentType: "text/csv",
+			Data:        data,
+		}},
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("[ROOM %s] report mail failed: %v", room.ID, err)
+	}
+}
+
+func buildReport(room *models.Room, m *models.MeetingSession, parts []models.Participant, now time.Time) *Report {
+	r := &Report{
+		SessionID:        m.ID,
+		RoomID:           room.ID,
+		Title:            room.Title,
+		StartedAt:        m.StartedAt,
+		EndedAt:          m.EndedAt,
+		PeakParticipants: m.PeakParticipants,
+		ChatMessages:     m.ChatMessages,
+		HandsRaised:      m.HandsRaised,
+		PollsRun:         m.PollsRun,
+	}
+
+	byUser := make(map[uuid.UUID]int)
+	for _, p := range parts {
+		i, ok := byUser[p.UserID]
+		if !ok {
+			i = len(r.Participants)
+			byUser[p.UserID] = i
+			r.Participants = append(r.Participants, AttendeeReport{
+				UserID:   p.UserID,
+				UserName: p.User.UserName,
+				Email:    p.User.Email,
+			})
+		}
+		a := &r.Participants[i]
+		a.Intervals = append(a.Intervals, Interval{JoinedAt: p.JoinedAt, LeftAt: p.LeftAt})
+		end := now
+		if p.LeftAt != nil {
+			end = *p.LeftAt
+		}
+		a.Seconds += int64(end.Sub(p.JoinedAt).Seconds())
+	}
+	return r
+}
+
+// peakConcurrency sweeps join and leave times to find the most distinct
+// users present at once.
+func peakConcurrency(parts []models.Participant, now time.Time) int {
+	type edge struct {
+		at    time.Time
+		delta int
+		user  uuid.UUID
+	}
+	edges := make([]edge, 0, 2*len(parts))
+	for _, p := range parts {
+		end := now
+		if p.LeftAt != nil {
+			end = *p.LeftAt
+		}
+		edges = append(edges, edge{p.JoinedAt, 1, p.UserID}, edge{end, -1, p.UserID})
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at.Equal(edges[j].at) {
+			return edges[i].delta < edges[j].delta
+		}
+		return edges[i].at.Before(edges[j].at)
+	})
+
+	present := make(map[uuid.UUID]int)
+	peak := 0
+	for _, e := range edges {
+		present[e.user] += e.delta
+		if present[e.user] == 0 {
+			delete(present, e.user)
+		}
+		if len(present) > peak {
+			peak = len(present)
+		}
+	}
+	return peak
+}
+
+func (r *Report) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	ended := ""
+	if r.EndedAt != nil {
+		ended = r.EndedAt.UTC().Format(time.RFC3339)
+	}
+	rows := [][]string{
+		{"room", r.Title},
+		{"session_id", r.SessionID.String()},
+		{"started_at", r.StartedAt.UTC().Format(time.RFC3339)},
+		{"ended_at", ended},
+		{"peak_participants", strconv.Itoa(r.PeakParticipants)},
+		{"chat_messages", strconv.Itoa(r.ChatMessages)},
+		{"hands_raised", strconv.Itoa(r.HandsRaised)},
+		{"polls_run", strconv.Itoa(r.PollsRun)},
+		{},
+		{"user_id", "user_name", "email", "joined_at", "left_at", "duration_seconds"},
+	}
+	for _, a := range r.Participants {
+		for _, iv := range a.Intervals {
+			left, dur := "", ""
+			if iv.LeftAt != nil {
+				left = iv.LeftAt.UTC().Format(time.RFC3339)
+				dur = strconv.FormatInt(int64(iv.LeftAt.Sub(iv.JoinedAt).Seconds()), 10)
+			}
+			rows = append(rows, []string{
+				a.UserID.String(), a.UserName, a.Email,
+				iv.JoinedAt.UTC().Format(time.RFC3339), left, dur,
+			})
+		}
+	}
+
+	w.UseCRLF = true
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
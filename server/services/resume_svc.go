@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"video-conference/models"
+	"video-conference/protocol"
+	"video-conference/repositories"
+
+	"github.com/google/uuid"
+)
+
+// claimResume takes over the session a dropped connection left under token,
+// if it belongs to this user and room.
+func (s *WebSocketService) claimResume(ctx context.Context, token, roomID, userID string) *models.ResumeState {
+	if token == "" || s.resumeGrace <= 0 {
+		return nil
+	}
+	state, err := s.roomRepo.ClaimResume(ctx, token)
+	if err != nil || state == nil {
+		return nil
+	}
+	if state.RoomID != roomID || state.UserID != userID {
+		// Not ours; put it back for its owner.
+		_ = s.roomRepo.HoldResume(ctx, token, state, s.resumeGrace)
+		return nil
+	}
+	return state
+}
+
+// holdForResume keeps a dropped user in the room for the resume window,
+// telling peers to hold on to their connections. If the token has not been
+// claimed by then the user leaves for good.
+func (s *WebSocketService) holdForResume(ctx context.Context, c *client, token string, pid uuid.UUID) bool {
+	if s.resumeGrace <= 0 {
+		return false
+	}
+	state := s.resumeState(c, pid)
+	// The key outlives the window so the timer below, not expiry, decides.
+	if err := s.roomRepo.HoldResume(ctx, token, state, 2*s.resumeGrace); err != nil {
+		log.Printf("[ROOM %s] holding session of %s failed: %v", c.roomID, c.userID, err)
+		return false
+	}
+	_ = s.roomRepo.PublishMessage(ctx, c.roomID, presence(protocol.TypeUserReconnecting, c.user, c.connID, state.Role))
+
+	user := c.user
+	s.holds.Add(1)
+	time.AfterFunc(s.resumeGrace, func() {
+		defer s.holds.Done()
+		bg := context.Background()
+		if claimed, _ := s.roomRepo.ClaimResume(bg, token); claimed != nil {
+			s.leave(bg, state.RoomID, user, state.ConnID, state.Role, pid)
+			return
+		}
+		// Resumed. If that happened on another node, it tracks the user now.
+		if !s.connectedHere(state.RoomID, state.UserID) {
+			s.handedOver(bg, state.RoomID, user, state.ConnID, state.Role, pid)
+		}
+	})
+	return true
+}
+
+// handedOver stops tracking a user whose session another node resumed. If
+// they already left there, that node saw them still tracked here and only
+// dropped the device, so they depart now.
+func (s *WebSocketService) handedOver(ctx context.Context, roomID string, user *models.User, connID string, role models.RoomRole, pid uuid.UUID) {
+	userID := user.ID.String()
+	_ = s.roomRepo.UntrackPresence(ctx, s.nodeID, roomID, userID)
+	if tracked, err := s.roomRepo.IsTracked(ctx, repositories.PresenceEntry{RoomID: roomID, UserID: userID}); err == nil && !tracked {
+		s.depart(ctx, roomID, user, connID, role, pid)
+	}
+}
+
+func (s *WebSocketService) resumeState(c *client, pid uuid.UUID) *models.ResumeState {
+	return &models.ResumeState{
+		RoomID:        c.roomID,
+		UserID:        c.userID,
+		ConnID:        c.connID,
+		Role:          s.RoleOf(c.roomID, c.userID),
+		ParticipantID: pid,
+	}
+}
+
+func (s *WebSocketService) connectedHere(roomID, userID string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.connections[roomID][userID]
+	return ok
+}
+
+// replay sends c the room events it missed after lastSeq and returns the
+// number of the last one. When the log no longer reaches back that far it
+// sends a fresh users-list instead and returns 0.
+func (s *WebSocketService) replay(ctx context.Context, c *client, lastSeq string) (seq int64, closed bool) {
+	after, err := strconv.ParseInt(lastSeq, 10, 64)
+	if err != nil {
+		after = -1
+	}
+	events, complete, err := s.roomRepo.EventsSince(ctx, c.roomID, after)
+	if after < 0 || err != nil || !complete {
+		c.send(s.usersList(ctx, c.room, c.userID))
+		return 0, false
+	}
+	for _, raw := range events {
+		if s.deliver(c, []byte(raw), after) {
+			return 0, true
+		}
+	}
+	if n := len(events); n > 0 {
+		var ev roomEvent
+		if protocol.Unmarshal([]byte(events[n-1]), &ev) == nil {
+			after = ev.Seq
+		}
+	}
+	return after, false
+}
@@ -0,0 +1,445 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"video-conference/models"
+	"video-conference/repositories"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidSlug  = errors.New("invalid slug")
+	ErrSlugTaken    = errors.New("slug already taken")
+	ErrInvalidOwner = errors.New("invalid owner")
+	ErrPersonalRoom = errors.New("personal rooms cannot change owner")
+	ErrInvalidTags  = errors.New("invalid tags")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const (
+	minSlugLength = 3
+	maxSlugLength = 48
+
+	maxTags         = 20
+	maxTagLength    = 32
+	maxSearchLimit  = 100
+	defSearchLimit  = 20
+	maxSearchLength = 200
+)
+
+var reservedSlugs = map[string]bool{
+	"admin": true, "api": true, "auth": true, "calendar": true, "health": true,
+	"help": true, "join": true, "login": true, "logout": true, "mainwindow": true,
+	"new": true, "profile": true, "r": true, "register": true, "room": true,
+	"rooms": true, "settings": true, "static": true, "support": true, "user": true,
+	"users": true, "videowindow": true, "ws": true,
+}
+
+type RoomService struct {
+	roomRepo *repositories.RoomRepository
+	userRepo *repositories.UserRepository
+	orgRepo  *repositories.OrgRepository
+}
+
+func NewRoomService(roomRepo *repositories.RoomRepository, userRepo *repositories.UserRepository, orgRepo *repositories.OrgRepository) *RoomService {
+	return &RoomService{roomRepo: roomRepo, userRepo: userRepo, orgRepo: orgRepo}
+}
+
+// Resolve looks a room up by UUID or slug. When ref is a slug the room has
+// since moved away from, canonical holds the slug to redirect to.
+func (s *RoomService) Resolve(ctx context.Context, ref string) (room *models.Room, canonical string, err error) {
+	if _, err := uuid.Parse(ref); err == nil {
+		room, err := s.roomRepo.GetRoom(ctx, ref)
+		if err != nil {
+			return nil, "", ErrRoomNotFound
+		}
+		return room, "", nil
+	}
+
+	slug := strings.ToLower(ref)
+	if room, err := s.roomRepo.GetRoomBySlug(ctx, slug); err == nil {
+		return room, "", nil
+	}
+	red, err := s.roomRepo.GetSlugRedirect(ctx, slug)
+	if err != nil {
+		return nil, "", ErrRoomNotFound
+	}
+	room, err = s.roomRepo.GetRoom(ctx, red.RoomID.String())
+	if err != nil {
+		return nil, "", ErrRoomNotFound
+	}
+	if room.Slug != nil {
+		canonical = *room.Slug
+	}
+	return room, canonical, nil
+}
+
+func (s *RoomService) SetSlug(ctx context.Context, ownerID uuid.UUID, roomID string, slug string) (*models.Room, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, ErrRoomNotFound
+	}
+	if !room.IsManager(ownerID.String()) {
+		return nil, ErrNotRoomOwner
+	}
+
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if err := validateSlug(slug); err != nil {
+		return nil, err
+	}
+	if room.Slug != nil && *room.Slug == slug {
+		return room, nil
+	}
+	if taken, err := s.roomRepo.SlugTaken(ctx, slug, room.ID); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, ErrSlugTaken
+	}
+
+	if err := s.roomRepo.SetSlug(ctx, room, slug); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// Managed returns the room if userID is its owner or one of its co-owners.
+func (s *RoomService) Managed(ctx context.Context, userID uuid.UUID, roomID string) (*models.Room, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, ErrRoomNotFound
+	}
+	if !room.IsManager(userID.String()) {
+		return nil, ErrNotRoomOwner
+	}
+	return room, nil
+}
+
+// TransferOwnership may be invoked by the owner, or by an admin of the
+// room's organization so that rooms can be recovered when their owner is
+// gone. Co-owners cannot take a room over.
+func (s *RoomService) TransferOwnership(ctx context.Context, actorID uuid.UUID, roomID, newOwner string, keepPrevious bool) (*models.Room, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, ErrRoomNotFound
+	}
+	if room.OwnerID != actorID && !s.isOrgAdmin(ctx, room, actorID) {
+		return nil, ErrNotRoomOwner
+	}
+	if room.ParentID != nil {
+		return nil, ErrInvalidOwner
+	}
+	u, err := s.lookupUser(ctx, newOwner)
+	if err != nil {
+		return nil, err
+	}
+	if u.ID == room.OwnerID {
+		return room, nil
+	}
+	if prev, _ := s.userRepo.GetUserByID(ctx, room.OwnerID.String()); prev != nil &&
+		prev.PersonalRoomID != nil && *prev.PersonalRoomID == room.ID {
+		return nil, ErrPersonalRoom
+	}
+
+	if err := s.roomRepo.TransferOwnership(ctx, room, u.ID, keepPrevious); err != nil {
+		return nil, err
+	}
+	return s.roomRepo.GetRoom(ctx, roomID)
+}
+
+func (s *RoomService) isOrgAdmin(ctx context.Context, room *models.Room, userID uuid.UUID) bool {
+	if room.OrgID == nil {
+		return false
+	}
+	m, err := s.orgRepo.GetMembership(ctx, room.OrgID.String(), userID.String())
+	return err == nil && m != nil && m.Role.CanAdmin()
+}
+
+func (s *RoomService) AddCoOwner(ctx context.Context, actorID uuid.UUID, roomID, user string) (*models.Room, error) {
+	room, err := s.Managed(ctx, actorID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.lookupUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if u.ID == room.OwnerID {
+		return nil, ErrInvalidOwner
+	}
+
+	if err := s.roomRepo.AddCoOwner(ctx, room.ID, u.ID); err != nil {
+		return nil, err
+	}
+	return s.roomRepo.GetRoom(ctx, roomID)
+}
+
+// RemoveCoOwner lets the owner drop any co-owner and a co-owner drop
+// themselves.
+func (s *RoomService) RemoveCoOwner(ctx context.Context, actorID uuid.UUID, roomID, userID string) (*models.Room, error) {
+	room, err := s.Managed(ctx, actorID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.OwnerID != actorID && actorID.String() != userID {
+		return nil, ErrNotRoomOwner
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil || !room.IsCoOwner(userID) {
+		return nil, ErrInvalidOwner
+	}
+
+	if err := s.roomRepo.RemoveCoOwner(ctx, room.ID, uid); err != nil {
+		return nil, err
+	}
+	return s.roomRepo.GetRoom(ctx, roomID)
+}
+
+func (s *RoomService) lookupUser(ctx context.Context, ref string) (*models.User, error) {
+	if u := findUser(ctx, s.userRepo, ref); u != nil {
+		return u, nil
+	}
+	return nil, ErrInvalidOwner
+}
+
+// findUser accepts either a user ID or an email address.
+func findUser(ctx context.Context, users *repositories.UserRepository, ref string) *models.User {
+	ref = strings.TrimSpace(ref)
+	var u *models.User
+	if _, err := uuid.Parse(ref); err == nil {
+		u, _ = users.GetUserByID(ctx, ref)
+	} else if strings.Contains(ref, "@") {
+		u, _ = users.GetUserByEmail(ctx, ref)
+	}
+	return u
+}
+
+func (s *RoomService) SetTags(ctx context.Context, actorID uuid.UUID, roomID string, tags []string) (*models.Room, error) {
+	room, err := s.Managed(ctx, actorID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.Tags, err = NormalizeTags(tags); err != nil {
+		return nil, err
+	}
+	if err := s.roomRepo.SetTags(ctx, room.ID, room.Tags); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+func (s *RoomService) Search(ctx context.Context, userID, query string, tags []string, limit, offset int) ([]repositories.RoomHit, error) {
+	query = strings.TrimSpace(query)
+	if len(query) > maxSearchLength {
+		query = query[:maxSearchLength]
+	}
+	tags, err := NormalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > maxSearchLimit {
+		limit = defSearchLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return s.roomRepo.SearchRooms(ctx, repositories.RoomSearch{
+		UserID: userID,
+		Query:  query,
+		Tags:   tags,
+		Limit:  limit,
+		Offset: offset,
+	})
+}
+
+// NormalizeTags lower-cases, trims and de-duplicates tags, keeping their
+// order.
+func NormalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.Join(strings.Fields(strings.ToLower(t)), " ")
+		if t == "" || seen[t] {
+			continue
+		}
+		if len(t) > maxTagLength || strings.ContainsAny(t, ",;") {
+			return nil, ErrInvalidTags
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) > maxTags {
+		return nil, ErrInvalidTags
+	}
+	return out, nil
+}
+
+// PersonalRoom returns the caller's permanent room, creating it on first use
+// with a slug derived from their user name.
+func (s *RoomService) PersonalRoom(ctx context.Context, userID string, org *models.Organization) (*models.Room, error) {
+	u, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil || u == nil {
+		return nil, errors.New("user not found")
+	}
+	if u.PersonalRoomID != nil {
+		if room, err := s.roomRepo.GetRoom(ctx, u.PersonalRoomID.String()); err == nil {
+			return room, nil
+		}
+	}
+
+	slug, err := s.freeSlug(ctx, u.UserName)
+	if err != nil {
+		return nil, err
+	}
+	room := &models.Room{
+		ID:              uuid.New(),
+		OwnerID:         u.ID,
+		Slug:            &slug,
+		Title:           u.UserName + "'s room",
+		Description:     "Personal meeting room",
+		MaxParticipants: 10,
+		IsActive:        true,
+		Permanent:       true,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	room.Place(org)
+	if err := s.roomRepo.CreateRoom(ctx, room); err != nil {
+		// A concurrent request may have taken the slug for the same room.
+		if theirs := s.claimedPersonalRoom(ctx, userID); theirs != nil {
+			return theirs, nil
+		}
+		return nil, err
+	}
+	claimed, err := s.userRepo.SetPersonalRoom(ctx, userID, u.PersonalRoomID, room.ID)
+	if err == nil && claimed {
+		return room, nil
+	}
+	// The write failed or another request got there first; keep theirs.
+	_ = s.roomRepo.DeleteRoom(ctx, room.ID)
+	if err != nil {
+		return nil, err
+	}
+	if theirs := s.claimedPersonalRoom(ctx, userID); theirs != nil {
+		return theirs, nil
+	}
+	return nil, errors.New("personal room unavailable")
+}
+
+func (s *RoomService) claimedPersonalRoom(ctx context.Context, userID string) *models.Room {
+	u, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil || u == nil || u.PersonalRoomID == nil {
+		return nil
+	}
+	room, err := s.roomRepo.GetRoom(ctx, u.PersonalRoomID.String())
+	if err != nil {
+		return nil
+	}
+	return room
+}
+
+// BackfillPersonalRooms gives every user that predates personal rooms one
+// in their home organization. Only one node does it at a time.
+func (s *RoomService) BackfillPersonalRooms(ctx context.Context) {
+	if ok, err := s.roomRepo.AcquireLock(ctx, "personal-room-backfill", 10*time.Minute); err != nil || !ok {
+		return
+	}
+
+	n, after := 0, uuid.Nil
+	for {
+		users, err := s.userRepo.ListWithoutPersonalRoom(ctx, after, 500)
+		if err != nil {
+			log.Printf("personal rooms: list users: %v", err)
+			return
+		}
+		if len(users) == 0 {
+			break
+		}
+		for _, u := range users {
+			after = u.ID
+			orgID := models.DefaultOrgID
+			if u.OrgID != nil {
+				orgID = *u.OrgID
+			}
+			org, err := s.orgRepo.GetOrg(ctx, orgID.String())
+			if err != nil || org == nil {
+				continue
+			}
+			if _, err := s.PersonalRoom(ctx, u.ID.String(), org); err != nil {
+				log.Printf("personal rooms: %s: %v", u.ID, err)
+				continue
+			}
+			n++
+		}
+	}
+	if n > 0 {
+		log.Printf("personal rooms: created %d for existing users", n)
+	}
+}
+
+func (s *RoomService) freeSlug(ctx context.Context, name string) (string, error) {
+	base := slugify(name)
+	if len(base) < minSlugLength {
+		base = "room"
+	}
+	if len(base) > maxSlugLength-5 {
+		base = strings.TrimRight(base[:maxSlugLength-5], "-")
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		if validateSlug(candidate) == nil {
+			taken, err := s.roomRepo.SlugTaken(ctx, candidate, uuid.Nil)
+			if err != nil {
+				return "", err
+			}
+			if !taken {
+				return candidate, nil
+			}
+		}
+		buf := make([]byte, 2)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		candidate = base + "-" + hex.EncodeToString(buf)
+	}
+	return "", ErrSlugTaken
+}
+
+func validateSlug(slug string) error {
+	if len(slug) < minSlugLength || len(slug) > maxSlugLength || !slugPattern.MatchString(slug) {
+		return ErrInvalidSlug
+	}
+	if reservedSlugs[slug] {
+		return ErrInvalidSlug
+	}
+	if _, err := uuid.Parse(slug); err == nil {
+		return ErrInvalidSlug
+	}
+	return nil
+}
+
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			dash = false
+		case !dash && b.Len() > 0:
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
@@ -0,0 +1,534 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"video-conference/ics"
+	"video-conference/mailer"
+	"video-conference/models"
+	"video-conference/recurrence"
+	"video-conference/repositories"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidSchedule = errors.New("invalid schedule")
+	ErrRoomNotFound    = errors.New("room not found")
+	ErrNotRoomOwner    = errors.New("not the room owner")
+)
+
+type ScheduleService struct {
+	roomRepo *repositories.RoomRepository
+	userRepo *repositories.UserRepository
+	mailer   mailer.Mailer
+	appURL   string
+	apiURL   string
+}
+
+type ScheduleInput struct {
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	StartsAt    time.Time  `json:"startsAt"`
+	EndsAt      time.Time  `json:"endsAt"`
+	TimeZone    string     `json:"timeZone"`
+	Recurrence  string     `json:"recurrence"`
+	Occurrence  *time.Time `json:"occurrence"`
+	Attendees   []string   `json:"attendees"`
+	OrgID       string     `json:"orgId"`
+}
+
+type Occurrence struct {
+	RoomID        uuid.UUID `json:"roomId"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	StartsAt      time.Time `json:"startsAt"`
+	EndsAt        time.Time `json:"endsAt"`
+	OriginalStart time.Time `json:"originalStart"`
+	Recurring     bool      `json:"recurring"`
+	JoinURL       string    `json:"joinUrl"`
+}
+
+func NewScheduleService(
+	roomRepo *repositories.RoomRepository,
+	userRepo *repositories.UserRepository,
+	m mailer.Mailer,
+	appURL string,
+	apiURL string,
+) *ScheduleService {
+	return &ScheduleService{
+		roomRepo: roomRepo,
+		userRepo: userRepo,
+		mailer:   m,
+		appURL:   strings.TrimRight(appURL, "/"),
+		apiURL:   strings.TrimRight(apiURL, "/"),
+	}
+}
+
+func (s *ScheduleService) Schedule(ctx context.Context, ownerID uuid.UUID, org *models.Organization, in ScheduleInput) (*models.Room, error) {
+	emails, err := validateSchedule(&in)
+	if err != nil {
+		return nil, err
+	}
+
+	room := &models.Room{
+		ID:              uuid.New(),
+		OwnerID:         ownerID,
+		Title:           in.Title,
+		Description:     in.Description,
+		MaxParticipants: 10,
+		IsActive:        true,
+		StartsAt:        &in.StartsAt,
+		EndsAt:          &in.EndsAt,
+		TimeZone:        in.TimeZone,
+		RRule:           in.Recurrence,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	room.Place(org)
+	if err := s.roomRepo.CreateRoom(ctx, room); err != nil {
+		return nil, err
+	}
+	if err := s.roomRepo.SetAttendees(ctx, room.ID, emails); err != nil {
+		return nil, err
+	}
+
+	s.notify(ctx, room, emails, ics.MethodRequest, s.events(ctx, room, emails))
+	return room, nil
+}
+
+func (s *ScheduleService) Reschedule(ctx context.Context, ownerID uuid.UUID, roomID string, in ScheduleInput) (*models.Room, error) {
+	room, err := s.ownedRoom(ctx, ownerID, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if in.Occurrence != nil {
+		return room, s.rescheduleOccurrence(ctx, room, *in.Occurrence, in)
+	}
+
+	emails, err := validateSchedule(&in)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := s.roomRepo.GetAttendees(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if room.StartsAt == nil || !room.StartsAt.Equal(in.StartsAt) ||
+		room.RRule != in.Recurrence || room.TimeZone != in.TimeZone {
+		if err := s.roomRepo.DeleteOccurrenceOverrides(ctx, roomID); err != nil {
+			return nil, err
+		}
+	}
+
+	room.Title = in.Title
+	room.Description = in.Description
+	room.StartsAt = &in.StartsAt
+	room.EndsAt = &in.EndsAt
+	room.TimeZone = in.TimeZone
+	room.RRule = in.Recurrence
+	room.Sequence++
+	if err := s.roomRepo.UpdateRoom(ctx, room); err != nil {
+		return nil, err
+	}
+	if err := s.roomRepo.SetAttendees(ctx, room.ID, emails); err != nil {
+		return nil, err
+	}
+
+	events := s.events(ctx, room, emails)
+	s.notify(ctx, room, emails, ics.MethodRequest, events)
+	s.notify(ctx, room, difference(previous, emails), ics.MethodCancel, events[:1])
+	return room, nil
+}
+
+func (s *ScheduleService) Cancel(ctx context.Context, ownerID uuid.UUID, roomID string, occurrence *time.Time) error {
+	room, err := s.ownedRoom(ctx, ownerID, roomID)
+	if err != nil {
+		return err
+	}
+	emails, err := s.roomRepo.GetAttendees(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	if occurrence != nil {
+		override, err := s.override(ctx, room, *occurrence)
+		if err != nil {
+			return err
+		}
+		override.Cancelled = true
+		if err := s.roomRepo.SaveOccurrenceOverride(ctx, override); err != nil {
+			return err
+		}
+		event := s.overrideEvent(s.seriesEvent(ctx, room, emails), override)
+		s.notify(ctx, room, emails, ics.MethodCancel, []ics.Event{event})
+		return nil
+	}
+
+	room.IsActive = false
+	room.Sequence++
+	if err := s.roomRepo.UpdateRoom(ctx, room); err != nil {
+		return err
+	}
+
+	s.notify(ctx, room, emails, ics.MethodCancel, []ics.Event{s.seriesEvent(ctx, room, emails)})
+	return nil
+}
+
+func (s *ScheduleService) rescheduleOccurrence(ctx context.Context, room *models.Room, original time.Time, in ScheduleInput) error {
+	if !in.EndsAt.After(in.StartsAt) || in.StartsAt.IsZero() {
+		return ErrInvalidSchedule
+	}
+	override, err := s.override(ctx, room, original)
+	if err != nil {
+		return err
+	}
+
+	override.StartsAt = &in.StartsAt
+	override.EndsAt = &in.EndsAt
+	override.Title = strings.TrimSpace(in.Title)
+	override.Description = in.Description
+	override.Cancelled = false
+	if err := s.roomRepo.SaveOccurrenceOverride(ctx, override); err != nil {
+		return err
+	}
+
+	emails, err := s.roomRepo.GetAttendees(ctx, room.ID.String())
+	if err != nil {
+		return err
+	}
+	event := s.overrideEvent(s.seriesEvent(ctx, room, emails), override)
+	s.notify(ctx, room, emails, ics.MethodRequest, []ics.Event{event})
+	return nil
+}
+
+// override returns the stored exception for one occurrence of a recurring
+// room, or a fresh one if that occurrence has not been edited yet. Its
+// sequence is always bumped so clients apply the change.
+func (s *ScheduleService) override(ctx context.Context, room *models.Room, original time.Time) (*models.RoomOccurrence, error) {
+	if room.RRule == "" || room.StartsAt == nil {
+		return nil, ErrInvalidSchedule
+	}
+	rule, err := recurrence.Parse(room.RRule)
+	if err != nil || !rule.Includes(room.StartsAt.In(location(room.TimeZone)), original) {
+		return nil, ErrInvalidSchedule
+	}
+
+	overrides, err := s.roomRepo.GetOccurrenceOverrides(ctx, room.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	for i := range overrides {
+		if overrides[i].OriginalStart.Equal(original) {
+			overrides[i].Sequence++
+			return &overrides[i], nil
+		}
+	}
+	return &models.RoomOccurrence{
+		RoomID:        room.ID,
+		OriginalStart: original.UTC(),
+		Sequence:      room.Sequence + 1,
+	}, nil
+}
+
+func (s *ScheduleService) Upcoming(ctx context.Context, userID string, from, to time.Time) ([]Occurrence, error) {
+	u, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil || u == nil {
+		return nil, errors.New("user not found")
+	}
+	rooms, err := s.roomRepo.ListUpcomingRooms(ctx, userID, u.Email, from)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Occurrence
+	for i := range rooms {
+		out = append(out, s.expand(ctx, &rooms[i], from, to)...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartsAt.Before(out[j].StartsAt) })
+	return out, nil
+}
+
+func (s *ScheduleService) expand(ctx context.Context, room *models.Room, from, to time.Time) []Occurrence {
+	duration := room.EndsAt.Sub(*room.StartsAt)
+	base := Occurrence{
+		RoomID:      room.ID,
+		Title:       room.Title,
+		Description: room.Description,
+		Recurring:   room.RRule != "",
+		JoinURL:     s.JoinURL(room.ID),
+	}
+
+	if room.RRule == "" {
+		if room.EndsAt.After(from) && room.StartsAt.Before(to) {
+			base.StartsAt, base.EndsAt, base.OriginalStart = *room.StartsAt, *room.EndsAt, *room.StartsAt
+			return []Occurrence{base}
+		}
+		return nil
+	}
+
+	rule, err := recurrence.Parse(room.RRule)
+	if err != nil {
+		return nil
+	}
+	overrides, _ := s.roomRepo.GetOccurrenceOverrides(ctx, room.ID.String())
+	overridden := make([]time.Time, len(overrides))
+	for i, o := range overrides {
+		overridden[i] = o.OriginalStart
+	}
+
+	var out []Occurrence
+	starts := rule.Between(room.StartsAt.In(location(room.TimeZone)), from.Add(-duration), to)
+	for _, start := range recurrence.Exclude(starts, overridden) {
+		o := base
+		o.StartsAt, o.EndsAt, o.OriginalStart = start, start.Add(duration), start
+		out = append(out, o)
+	}
+	for _, ov := range overrides {
+		if ov.Cancelled || ov.StartsAt == nil || !ov.EndsAt.After(from) || !ov.StartsAt.Before(to) {
+			continue
+		}
+		o := base
+		o.StartsAt, o.EndsAt, o.OriginalStart = *ov.StartsAt, *ov.EndsAt, ov.OriginalStart
+		if ov.Title != "" {
+			o.Title = ov.Title
+		}
+		if ov.Description != "" {
+			o.Description = ov.Description
+		}
+		out = append(out, o)
+	}
+	return out
+}
+
+func (s *ScheduleService) FeedURL(ctx context.Context, userID string) (string, error) {
+	u, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil || u == nil {
+		return "", errors.New("user not found")
+	}
+
+	token := u.FeedToken
+	if token == "" {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		token = hex.EncodeToString(buf)
+		if err := s.userRepo.SetFeedToken(ctx, userID, token); err != nil {
+			return "", err
+		}
+	}
+	return s.apiURL + "/video-conference/calendar/" + token + ".ics", nil
+}
+
+func (s *ScheduleService) Feed(ctx context.Context, token string) (string, error) {
+	u, err := s.userRepo.GetUserByFeedToken(ctx, token)
+	if err != nil || u == nil {
+		return "", ErrRoomNotFound
+	}
+
+	rooms, err := s.roomRepo.ListUpcomingRooms(ctx, u.ID.String(), u.Email, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	cal := ics.Calendar{Method: ics.MethodPublish, Name: "Meetings – " + u.UserName}
+	for i := range rooms {
+		emails, _ := s.roomRepo.GetAttendees(ctx, rooms[i].ID.String())
+		cal.Events = append(cal.Events, s.events(ctx, &rooms[i], emails)...)
+	}
+	return cal.String(), nil
+}
+
+func (s *ScheduleService) JoinURL(roomID uuid.UUID) string {
+	return s.appURL + "/videoWindow?room=" + roomID.String()
+}
+
+// events returns the series VEVENT followed by one VEVENT per edited
+// occurrence; cancelled occurrences become EXDATEs on the series.
+func (s *ScheduleService) events(ctx context.Context, room *models.Room, emails []string) []ics.Event {
+	series := s.seriesEvent(ctx, room, emails)
+	if room.RRule == "" {
+		return []ics.Event{series}
+	}
+
+	overrides, _ := s.roomRepo.GetOccurrenceOverrides(ctx, room.ID.String())
+	var edited []ics.Event
+	for i := range overrides {
+		if overrides[i].Cancelled {
+			series.ExDates = append(series.ExDates, overrides[i].OriginalStart)
+			continue
+		}
+		edited = append(edited, s.overrideEvent(series, &overrides[i]))
+	}
+	return append([]ics.Event{series}, edited...)
+}
+
+func (s *ScheduleService) seriesEvent(ctx context.Context, room *models.Room, emails []string) ics.Event {
+	e := ics.Event{
+		UID:         room.ID.String() + "@video-conference",
+		Sequence:    room.Sequence,
+		Summary:     room.Title,
+		Description: room.Description,
+		URL:         s.JoinURL(room.ID),
+		Start:       *room.StartsAt,
+		End:         *room.EndsAt,
+		Location:    location(room.TimeZone),
+		RRule:       room.RRule,
+		Stamp:       room.UpdatedAt,
+	}
+	if owner, _ := s.userRepo.GetUserByID(ctx, room.OwnerID.String()); owner != nil {
+		e.Organizer = ics.Person{Name: owner.UserName, Email: owner.Email}
+	}
+	for _, email := range emails {
+		e.Attendees = append(e.Attendees, ics.Person{Email: email})
+	}
+	return e
+}
+
+func (s *ScheduleService) overrideEvent(series ics.Event, o *models.RoomOccurrence) ics.Event {
+	e := series
+	e.RRule = ""
+	e.ExDates = nil
+	e.RecurrenceID = &o.OriginalStart
+	e.Sequence = o.Sequence
+	e.Stamp = time.Now()
+
+	duration := series.End.Sub(series.Start)
+	e.Start, e.End = o.OriginalStart, o.OriginalStart.Add(duration)
+	if o.StartsAt != nil && o.EndsAt != nil {
+		e.Start, e.End = *o.StartsAt, *o.EndsAt
+	}
+	if o.Title != "" {
+		e.Summary = o.Title
+	}
+	if o.Description != "" {
+		e.Description = o.Description
+	}
+	if o.Cancelled {
+		e.Status = "CANCELLED"
+	}
+	return e
+}
+
+func (s *ScheduleService) notify(ctx context.Context, room *models.Room, emails []string, method string, events []ics.Event) {
+	if len(emails) == 0 || len(events) == 0 {
+		return
+	}
+
+	first := events[0]
+	subject := "Invitation: " + first.Summary
+	switch {
+	case method == ics.MethodCancel:
+		subject = "Canceled: " + first.Summary
+	case room.Sequence > 0 || first.RecurrenceID != nil:
+		subject = "Updated invitation: " + first.Summary
+	}
+
+	loc := location(room.TimeZone)
+	when := fmt.Sprintf("%s – %s (%s)",
+		first.Start.In(loc).Format("Mon Jan 2 2006 15:04"),
+		first.End.In(loc).Format("15:04"),
+		room.TimeZone)
+	if first.RRule != "" && first.RecurrenceID == nil {
+		when += "\nRepeats: " + first.RRule
+	}
+
+	cal := ics.Calendar{Method: method, Events: events}
+	msg := mailer.Message{
+		To:      emails,
+		Subject: subject,
+		Body:    fmt.Sprintf("%s\n\n%s\n\nJoin: %s\n", first.Summary, when, s.JoinURL(room.ID)),
+		Attachments: []mailer.Attachment{{
+			Filename:    "invite.ics",
+			ContentType: "text/calendar; charset=utf-8; method=" + method,
+			Data:        []byte(cal.String()),
+		}},
+	}
+	if err := s.mailer.Send(ctx, msg); err != nil {
+		log.Printf("[ROOM %s] invite mail failed: %v", room.ID, err)
+	}
+}
+
+func (s *ScheduleService) ownedRoom(ctx context.Context, ownerID uuid.UUID, roomID string) (*models.Room, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, ErrRoomNotFound
+	}
+	if !room.IsManager(ownerID.String()) {
+		return nil, ErrNotRoomOwner
+	}
+	return room, nil
+}
+
+func validateSchedule(in *ScheduleInput) ([]string, error) {
+	in.Title = strings.TrimSpace(in.Title)
+	if in.Title == "" || in.StartsAt.IsZero() || !in.EndsAt.After(in.StartsAt) {
+		return nil, ErrInvalidSchedule
+	}
+	if in.TimeZone == "" {
+		in.TimeZone = "UTC"
+	}
+	loc, err := time.LoadLocation(in.TimeZone)
+	if err != nil {
+		return nil, ErrInvalidSchedule
+	}
+
+	if in.Recurrence != "" {
+		rule, err := recurrence.Parse(in.Recurrence)
+		if err != nil {
+			return nil, ErrInvalidSchedule
+		}
+		start := in.StartsAt.In(loc)
+		if !rule.Includes(start, start) {
+			return nil, ErrInvalidSchedule
+		}
+		in.Recurrence = rule.String()
+	}
+
+	seen := make(map[string]bool, len(in.Attendees))
+	emails := make([]string, 0, len(in.Attendees))
+	for _, raw := range in.Attendees {
+		addr, err := mail.ParseAddress(raw)
+		if err != nil {
+			return nil, ErrInvalidSchedule
+		}
+		email := strings.ToLower(addr.Address)
+		if !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+	return emails, nil
+}
+
+func location(tz string) *time.Location {
+	if loc, err := time.LoadLocation(tz); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+func difference(a, b []string) []string {
+	keep := make(map[string]bool, len(b))
+	for _, v := range b {
+		keep[v] = true
+	}
+	var out []string
+	for _, v := range a {
+		if !keep[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"video-conference/models"
+	"video-conference/protocol"
+)
+
+const lobbyTimeout = 5 * time.Minute
+
+var (
+	errChatDisabled      = errors.New("chat disabled")
+	errScreenShareDenied = errors.New("screen share not allowed")
+	errRecordingDenied   = errors.New("recording not allowed")
+)
+
+var settingsEvents = map[string]string{
+	protocol.TypeScreenShareStart: protocol.TypeScreenShareStarted,
+	protocol.TypeScreenShareStop:  protocol.TypeScreenShareStopped,
+	protocol.TypeRecordingStart:   protocol.TypeRecordingStarted,
+	protocol.TypeRecordingStop:    protocol.TypeRecordingStopped,
+}
+
+func (s *WebSocketService) settingsOf(roomID string) models.RoomSettings {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if settings, ok := s.settings[roomID]; ok {
+		return settings
+	}
+	return models.DefaultRoomSettings()
+}
+
+func (s *WebSocketService) setSettings(roomID string, settings models.RoomSettings) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.settings[roomID] = settings
+}
+
+// waitInLobby holds a connection until a host admits or denies it. Hosts,
+// co-hosts and users admitted earlier in the meeting skip the lobby.
+func (s *WebSocketService) waitInLobby(ctx context.Context, c *client, role models.RoomRole) bool {
+	roomID, user, userID := c.roomID, c.user, c.userID
+	if !s.settingsOf(roomID).Lobby || role.CanModerate() {
+		return true
+	}
+	if ok, _ := s.roomRepo.IsAdmitted(ctx, roomID, userID); ok {
+		return true
+	}
+
+	sub, err := s.roomRepo.SubscribeToUser(ctx, userID)
+	if err != nil {
+		return false
+	}
+	defer s.roomRepo.UnsubscribeFromRoom(ctx, sub)
+
+	c.send(protocol.Event(protocol.TypeLobbyWaiting))
+	_ = s.roomRepo.PublishMessage(ctx, roomID, protocol.LobbyRequest{
+		Envelope: protocol.Event(protocol.TypeLobbyRequest),
+		UserInfo: protocol.UserInfo{UserID: userID, UserName: user.UserName, ImgUrl: user.ImgUrl},
+	})
+
+	timeout := time.NewTimer(lobbyTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-timeout.C:
+			c.send(protocol.Event(protocol.TypeLobbyTimeout))
+			return false
+		case msg, ok := <-sub.Channel:
+			if !ok {
+				return false
+			}
+			var decision protocol.LobbyDecision
+			if protocol.Unmarshal([]byte(msg.Payload), &decision) != nil || decision.RoomID != roomID {
+				continue
+			}
+			switch decision.Type {
+			case protocol.TypeLobbyAdmitted:
+				return true
+			case protocol.TypeLobbyDenied:
+				c.send(decision)
+				return false
+			}
+		}
+	}
+}
+
+func (s *WebSocketService) resolveLobby(ctx context.Context, c *client, m *protocol.LobbyAction) error {
+	roomID, target := c.roomID, m.Target
+	if !s.RoleOf(roomID, c.userID).CanModerate() {
+		return errForbidden
+	}
+
+	admitted := m.Type == protocol.TypeAdmit
+	kind := protocol.TypeLobbyDenied
+	if admitted {
+		kind = protocol.TypeLobbyAdmitted
+		if err := s.roomRepo.Admit(ctx, roomID, target); err != nil {
+			return err
+		}
+	}
+	decision := protocol.LobbyDecision{Envelope: protocol.Event(kind), RoomID: roomID}
+	if _, err := s.roomRepo.PublishToUser(ctx, target, decision); err != nil {
+		return err
+	}
+	return s.roomRepo.PublishMessage(ctx, roomID, protocol.LobbyResolved{
+		Envelope: protocol.Envelope{Type: protocol.TypeLobbyResolved, Actor: c.userID, Target: target},
+		Admitted: admitted,
+	})
+}
+
+func (s *WebSocketService) useFeature(ctx context.Context, c *client, m *protocol.Request) error {
+	roomID, userID, action := c.roomID, c.userID, m.Type
+	settings := s.settingsOf(roomID)
+	role := s.RoleOf(roomID, userID)
+
+	switch action {
+	case protocol.TypeScreenShareStart:
+		switch settings.ScreenShare {
+		case models.ScreenShareDisabled:
+			return errScreenShareDenied
+		case models.ScreenShareHosts:
+			if !role.CanModerate() && role != models.RolePresenter {
+				return errScreenShareDenied
+			}
+		}
+	case protocol.TypeRecordingStart, protocol.TypeRecordingStop:
+		if !settings.RecordingAllowed || !role.CanModerate() {
+			return errRecordingDenied
+		}
+	}
+
+	return s.roomRepo.PublishMessage(ctx, roomID, protocol.FeatureEvent{
+		Envelope: protocol.Envelope{Type: settingsEvents[action], Actor: userID},
+		UserID:   userID,
+	})
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"video-conference/models"
+	"video-conference/protocol"
+	"video-conference/repositories"
+
+	"github.com/google/uuid"
+)
+
+var ErrTemplateNotFound = errors.New("template not found")
+
+type TemplateService struct {
+	tplRepo  *repositories.TemplateRepository
+	roomRepo *repositories.RoomRepository
+	orgRepo  *repositories.OrgRepository
+}
+
+func NewTemplateService(
+	tplRepo *repositories.TemplateRepository,
+	roomRepo *repositories.RoomRepository,
+	orgRepo *repositories.OrgRepository,
+) *TemplateService {
+	return &TemplateService{tplRepo: tplRepo, roomRepo: roomRepo, orgRepo: orgRepo}
+}
+
+func (s *TemplateService) List(ctx context.Context, ownerID string) ([]models.RoomTemplate, error) {
+	return s.tplRepo.ListTemplates(ctx, ownerID)
+}
+
+func (s *TemplateService) Create(ctx context.Context, ownerID uuid.UUID, name string, raw json.RawMessage) (*models.RoomTemplate, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, models.ErrInvalidSettings
+	}
+	settings, err := overlaySettings(models.DefaultRoomSettings(), raw)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &models.RoomTemplate{
+		ID:        uuid.New(),
+		OwnerID:   ownerID,
+		Name:      name,
+		Settings:  settings,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := s.tplRepo.CreateTemplate(ctx, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *TemplateService) Update(ctx context.Context, ownerID uuid.UUID, id string, name string, raw json.RawMessage) (*models.RoomTemplate, error) {
+	t, err := s.owned(ctx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := overlaySettings(t.Settings, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if name = strings.TrimSpace(name); name != "" {
+		t.Name = name
+	}
+	t.Settings = settings
+	t.UpdatedAt = time.Now()
+	if err := s.tplRepo.UpdateTemplate(ctx, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *TemplateService) Delete(ctx context.Context, ownerID uuid.UUID, id string) error {
+	if _, err := s.owned(ctx, ownerID, id); err != nil {
+		return err
+	}
+	return s.tplRepo.DeleteTemplate(ctx, id)
+}
+
+// BuildSettings layers explicit settings over a template, which is itself
+// layered over the organization's defaults.
+func (s *TemplateService) BuildSettings(ctx context.Context, ownerID uuid.UUID, org *models.Organization, templateID string, raw json.RawMessage) (models.RoomSettings, error) {
+	base := org.DefaultSettings()
+	if templateID != "" {
+		t, err := s.owned(ctx, ownerID, templateID)
+		if err != nil {
+			return base, err
+		}
+		base = t.Settings
+	}
+	settings, err := overlaySettings(base, raw)
+	if err != nil {
+		return settings, err
+	}
+	return settings, org.CheckLimits(settings)
+}
+
+func (s *TemplateService) UpdateRoomSettings(ctx context.Context, ownerID uuid.UUID, roomID string, raw json.RawMessage) (models.RoomSettings, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return models.RoomSettings{}, ErrRoomNotFound
+	}
+	if !room.IsManager(ownerID.String()) {
+		return models.RoomSettings{}, ErrNotRoomOwner
+	}
+	settings, err := overlaySettings(room.Settings, raw)
+	if err != nil {
+		return models.RoomSettings{}, err
+	}
+	if room.OrgID != nil {
+		if org, _ := s.orgRepo.GetOrg(ctx, room.OrgID.String()); org != nil {
+			if err := org.CheckLimits(settings); err != nil {
+				return models.RoomSettings{}, err
+			}
+		}
+	}
+
+	room.ApplySettings(settings)
+	if err := s.roomRepo.UpdateRoom(ctx, room); err != nil {
+		return models.RoomSettings{}, err
+	}
+	_ = s.roomRepo.PublishMessage(ctx, roomID, protocol.Settings{Envelope: protocol.Event(protocol.TypeSettingsUpdated), Settings: settings})
+	return settings, nil
+}
+
+func (s *TemplateService) owned(ctx context.Context, ownerID uuid.UUID, id string) (*models.RoomTemplate, error) {
+	t, err := s.tplRepo.GetTemplate(ctx, id)
+	if err != nil || t == nil || t.OwnerID != ownerID {
+		return nil, ErrTemplateNotFound
+	}
+	return t, nil
+}
+
+func overlaySettings(base models.RoomSettings, raw json.RawMessage) (models.RoomSettings, error) {
+	if len(raw) > 0 && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &base); err != nil {
+			return base, models.ErrInvalidSettings
+		}
+	}
+	return base, base.Validate()
+}
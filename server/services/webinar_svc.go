@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"slices"
+
+	"video-conference/models"
+	"video-conference/protocol"
+)
+
+// hasCapacity applies the global connection cap to meetings. Webinars count
+// panelists against MaxParticipants and attendees against MaxAttendees so a
+// large audience never crowds out speakers. Users are counted on every node.
+func (s *WebSocketService) hasCapacity(ctx context.Context, room *models.Room, userID string, role models.RoomRole) bool {
+	roomID := room.ID.String()
+	present, err := s.roomRepo.PresentUsers(ctx, roomID)
+	if err != nil {
+		return false
+	}
+	others := slices.DeleteFunc(present, func(id string) bool { return id == userID })
+
+	if room.Type != models.RoomWebinar {
+		return len(others) < min(s.maxConnections, room.MaxParticipants)
+	}
+
+	roles, err := s.roomRepo.Roles(ctx, roomID)
+	if err != nil {
+		return false
+	}
+	panelist := role.IsPanelist()
+	n := 0
+	for _, id := range others {
+		if roles[id].IsPanelist() == panelist {
+			n++
+		}
+	}
+	if panelist {
+		return n < room.MaxParticipants
+	}
+	return n < room.MaxAttendees
+}
+
+func (s *WebSocketService) hasPanelistSeat(ctx context.Context, roomID string) bool {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return false
+	}
+	return room.Type != models.RoomWebinar || s.hasCapacity(ctx, room, "", models.RolePresenter)
+}
+
+// canSignal keeps webinar attendees receive-only: they may answer panelists
+// and exchange ICE with them, but never offer or reach other attendees.
+func (s *WebSocketService) canSignal(room *models.Room, from, to, kind string) bool {
+	if room.Type != models.RoomWebinar {
+		return true
+	}
+	roomID := room.ID.String()
+	if s.RoleOf(roomID, from).IsPanelist() {
+		return true
+	}
+	return kind != protocol.TypeOffer && s.RoleOf(roomID, to).IsPanelist()
+}
+
+func (s *WebSocketService) CanSeeAttendees(room *models.Room, userID string) bool {
+	return room.Type != models.RoomWebinar ||
+		room.IsManager(userID) ||
+		s.RoleOf(room.ID.String(), userID).IsPanelist()
+}
+
+var attendeeEvents = map[string]bool{
+	protocol.TypeUserJoined:       true,
+	protocol.TypeUserLeft:         true,
+	protocol.TypeUserReconnecting: true,
+	protocol.TypeUserReconnected:  true,
+	protocol.TypeDeviceJoined:     true,
+	protocol.TypeDeviceLeft:       true,
+	protocol.TypeHandRaised:       true,
+	protocol.TypeHandLowered:      true,
+}
+
+// visibleTo hides other attendees' presence from webinar attendees.
+func (s *WebSocketService) visibleTo(room *models.Room, userID string, ev roomEvent) bool {
+	if !attendeeEvents[ev.Type] || s.CanSeeAttendees(room, userID) {
+		return true
+	}
+
+	subject := ev.Sender
+	if subject == "" {
+		subject = ev.Target
+	}
+	if ev.Role != "" {
+		return ev.Role.IsPanelist()
+	}
+	return subject == userID || s.RoleOf(room.ID.String(), subject).IsPanelist()
+}
@@ -2,13 +2,19 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"video-conference/models"
+	"video-conference/protocol"
 	"video-conference/repositories"
 
+	fastws "github.com/fasthttp/websocket"
 	"github.com/gofiber/websocket/v2"
 	"github.com/google/uuid"
 )
@@ -16,161 +22,455 @@ import (
 type WebSocketService struct {
 	roomRepo *repositories.RoomRepository
 	userRepo *repositories.UserRepository
+	quotas   *QuotaService
+	reports  *ReportService
+	audits   *repositories.AuditRepository
 
-	connections map[string]map[string]*websocket.Conn
+	connections map[string]map[string]map[string]*client // room → user → connection
+	roles       map[string]map[string]models.RoomRole
+	settings    map[string]models.RoomSettings
 	mutex       sync.RWMutex
+	handlers    map[string]handlerFunc
+	encoded     *encodedCache
+	draining    atomic.Bool
+	drain       chan struct{}
+	drainBy     time.Time
+	holds       sync.WaitGroup // sessions held for resume
 
 	iceServers     []string
 	maxConnections int
+	pingInterval   time.Duration
+	idleTimeout    time.Duration
+	writeTimeout   time.Duration
+	resumeGrace    time.Duration
+	nodeID         string
+	rateLimits     map[string]models.RateLimit
+	maxMessageSize int64
+	maxStrikes     int
 }
 
 func NewWebSocketService(
 	roomRepo *repositories.RoomRepository,
 	userRepo *repositories.UserRepository,
+	quotas *QuotaService,
+	reports *ReportService,
+	audits *repositories.AuditRepository,
 	iceServers []string,
 	maxConns int,
+	pingInterval time.Duration,
+	idleTimeout time.Duration,
+	writeTimeout time.Duration,
+	resumeGrace time.Duration,
+	nodeID string,
+	rateLimits map[string]models.RateLimit,
+	maxMessageSize int64,
+	maxStrikes int,
 ) *WebSocketService {
-	return &WebSocketService{
+	s := &WebSocketService{
 		roomRepo:       roomRepo,
 		userRepo:       userRepo,
-		connections:    make(map[string]map[string]*websocket.Conn),
+		quotas:         quotas,
+		reports:        reports,
+		audits:         audits,
+		connections:    make(map[string]map[string]map[string]*client),
+		roles:          make(map[string]map[string]models.RoomRole),
+		settings:       make(map[string]models.RoomSettings),
+		drain:          make(chan struct{}),
+		encoded:        newEncodedCache(),
 		iceServers:     iceServers,
 		maxConnections: maxConns,
+		pingInterval:   pingInterval,
+		idleTimeout:    idleTimeout,
+		writeTimeout:   writeTimeout,
+		resumeGrace:    resumeGrace,
+		nodeID:         nodeID,
+		rateLimits:     rateLimits,
+		maxMessageSize: maxMessageSize,
+		maxStrikes:     maxStrikes,
 	}
+	s.handlers = s.requestHandlers()
+	return s
 }
 
 func (s *WebSocketService) HandleConnection(ctx context.Context, conn *websocket.Conn, roomID string, userID string) {
-	s.mutex.Lock()
-	roomMap, ok := s.connections[roomID]
-	if !ok {
-		roomMap = make(map[string]*websocket.Conn)
-		s.connections[roomID] = roomMap
+	c := newClient(conn, userID, s.pingInterval, s.writeTimeout)
+	c.limiter = newLimiter(s.rateLimits)
+	c.encoding = protocol.EncodingOf(conn.Subprotocol())
+	defer c.stop()
+	if s.draining.Load() {
+		c.send(s.drainNotice())
+		c.close(websocket.CloseServiceRestart, "server draining")
+		return
 	}
-	roomMap[userID] = conn
-	peerCount := len(roomMap)
-	s.mutex.Unlock()
 
-	defer s.cleanupConnection(ctx, roomID, userID)
-
-	if peerCount > s.maxConnections {
-		_ = conn.WriteJSON(fiberMap("error", "room full"))
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		c.send(protocol.Errorf(protocol.CodeRoomNotFound, "unknown room"))
 		return
 	}
+	c.room, c.roomID = room, roomID
 
-	if err := s.roomRepo.AddParticipant(ctx, roomID, userID); err != nil {
-		return
+	resumed := s.claimResume(ctx, conn.Query("resume"), roomID, userID)
+	if resumed == nil {
+		c.send(s.usersList(ctx, room, userID))
+	} else {
+		c.connID = resumed.ConnID
+	}
+
+	s.register(c)
+	// held is set once a dropped user is kept in the room for resume.
+	held := false
+	defer s.cleanupConnection(c)
+
+	var role models.RoomRole
+	if resumed != nil {
+		role = resumed.Role
+		s.setRole(ctx, roomID, userID, role)
+	} else {
+		role = s.assignRole(ctx, room, userID)
+		if !s.hasCapacity(ctx, room, userID, role) {
+			c.send(protocol.Errorf(protocol.CodeRoomFull, "room full"))
+			return
+		}
+		if err := s.quotas.CheckJoin(ctx, room, userID); err != nil {
+			c.send(asProtocolError(err))
+			return
+		}
 	}
-	defer s.roomRepo.RemoveParticipant(ctx, roomID, userID)
 
-	_ = conn.WriteJSON(fiberMap("type", "iceServers", "iceServers", s.iceServers))
+	c.version = protocol.FromSubprotocol(conn.Subprotocol())
+	if c.version == 0 {
+		c.version = protocol.V1
+	} else {
+		c.send(welcome(c))
+	}
+	c.send(protocol.Settings{Envelope: protocol.Event(protocol.TypeRoomSettings), Settings: room.Settings})
 
 	user := s.ensureUser(ctx, userID)
+	c.user = user
+	if resumed == nil && !s.waitInLobby(ctx, c, role) {
+		return
+	}
+
+	// Whether the user is already here on another device decides between
+	// announcing a new participant and announcing a new device.
+	again := s.otherConns(roomID, userID, c.connID) > 0
+	if !again {
+		again, _ = s.roomRepo.IsTracked(ctx, repositories.PresenceEntry{RoomID: roomID, UserID: userID})
+	}
+	if err := s.roomRepo.AddParticipant(ctx, roomID, userID); err != nil {
+		return
+	}
+	if err := s.roomRepo.TrackPresence(ctx, s.nodeID, roomID, userID); err != nil {
+		log.Printf("[ROOM %s] tracking presence of %s failed: %v", roomID, userID, err)
+	}
+	pid := uuid.Nil
+	switch {
+	case resumed != nil:
+		pid = resumed.ParticipantID
+	case again:
+		// One participation covers all of a user's devices.
+		pid, _ = s.roomRepo.OpenParticipation(ctx, room.ID, user.ID)
+	}
+	if pid == uuid.Nil {
+		pid = s.recordJoin(ctx, room, user)
+	}
+	defer func() {
+		if !held {
+			s.leave(ctx, roomID, user, c.connID, s.RoleOf(roomID, userID), pid)
+		}
+	}()
 
-	join := fiberMap(
-		"type", "user-joined",
-		"userID", user.ID,
-		"userName", user.UserName,
-		"imgUrl", user.ImgUrl,
-		"sender", userID,
-	)
-	_ = s.roomRepo.PublishMessage(ctx, roomID, join)
+	c.send(protocol.IceServers{Envelope: protocol.Event(protocol.TypeIceServers), IceServers: s.iceServers})
+	c.send(protocol.RoleAssigned{Envelope: protocol.Event(protocol.TypeRole), Role: role, RoomType: room.Type})
+	if resumed == nil && room.Settings.MuteOnEntry && !role.CanModerate() {
+		c.send(protocol.Moderation{Envelope: protocol.Envelope{Type: protocol.TypeMuteRequested, Target: userID}})
+	}
 
-	sub, err := s.roomRepo.SubscribeToRoom(ctx, roomID)
+	sub, err := s.roomRepo.SubscribeToRoom(ctx, roomID, userID)
 	if err != nil {
 		return
 	}
 	defer s.roomRepo.UnsubscribeFromRoom(ctx, sub)
 
-	go s.readFromClient(ctx, conn, roomID, userID, user)
+	// Events already replayed may also arrive live; skip those up to seen.
+	seen, _ := s.roomRepo.RoomSeq(ctx, roomID)
+	replayed := int64(0)
+	if resumed != nil {
+		var closed bool
+		if replayed, closed = s.replay(ctx, c, conn.Query("seq")); closed {
+			return
+		}
+		_ = s.roomRepo.PublishMessage(ctx, roomID, presence(protocol.TypeUserReconnected, user, c.connID, role))
+	} else {
+		s.announceJoin(ctx, c, role, again)
+	}
 
-	for msg := range sub.Channel {
-		var payload map[string]any
-		if json.Unmarshal([]byte(msg.Payload), &payload) != nil {
-			continue
+	token := uuid.NewString()
+	c.send(protocol.Session{
+		Envelope:     protocol.Event(protocol.TypeSession),
+		ConnID:       c.connID,
+		ResumeToken:  token,
+		LastSeq:      max(seen, replayed),
+		ResumeWindow: int(s.resumeGrace / time.Second),
+		Resumed:      resumed != nil,
+	})
+
+	done, read := make(chan error, 1), make(chan struct{})
+	go func() {
+		defer close(read)
+		done <- s.readFromClient(ctx, c)
+	}()
+	// The socket is released once the handler returns, so the reader must
+	// be gone by then.
+	defer func() {
+		c.stop()
+		_ = conn.Close()
+		<-read
+	}()
+
+	drain, offered := s.drain, false
+	for {
+		select {
+		case err := <-done:
+			if offered {
+				held = s.migrated(ctx, c, token, pid)
+				return
+			}
+			if isTimeout(err) {
+				log.Printf("[ROOM %s] %s stopped responding", roomID, userID)
+			}
+			if !s.draining.Load() && !errors.Is(err, errAbuse) && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				held = s.holdForResume(ctx, c, token, pid)
+			}
+			return
+		case <-drain:
+			drain = nil
+			offered = s.offerMigration(ctx, c, token, pid)
+		case msg, ok := <-sub.Channel:
+			if !ok || s.deliver(c, []byte(msg.Payload), replayed) {
+				return
+			}
 		}
-		if payload["sender"] == userID {
-			continue
+	}
+}
+
+// deliver forwards one room event to c and applies it, skipping events
+// numbered at or before after. It reports whether c is being closed.
+func (s *WebSocketService) deliver(c *client, raw []byte, after int64) bool {
+	var ev roomEvent
+	if protocol.Unmarshal(raw, &ev) != nil {
+		return false
+	}
+	if (ev.Seq != 0 && ev.Seq <= after) || ev.Sender == c.userID || !s.visibleTo(c.room, c.userID, ev) {
+		return false
+	}
+	if signalTypes[ev.Type] && (ev.RoomID != c.roomID || (ev.ToConn != "" && ev.ToConn != c.connID)) {
+		return false
+	}
+	if ev.Type == protocol.TypeSessionReplaced && (ev.RoomID != c.roomID || ev.ConnID == c.connID) {
+		return false
+	}
+	if data, ok := s.encodedFor(c, ev, raw); ok {
+		c.sendRaw(ev.Type, data)
+	}
+	if ev.Type == protocol.TypeSessionReplaced {
+		c.close(websocket.CloseNormalClosure, "joined from another device")
+		return true
+	}
+	if code, reason := s.applyRoomEvent(c.roomID, c.userID, ev, raw); code != 0 {
+		c.close(code, reason)
+		return true
+	}
+	return false
+}
+
+func (s *WebSocketService) recordJoin(ctx context.Context, room *models.Room, user *models.User) uuid.UUID {
+	sessionID := uuid.Nil
+	if sess, _ := s.userRepo.GetSessionByUserID(ctx, user.ID.String()); sess != nil {
+		sessionID = sess.ID
+	}
+	meetingID := s.reports.Begin(ctx, room)
+	pid, err := s.roomRepo.RecordJoin(ctx, room.ID, user.ID, sessionID, meetingID)
+	if err != nil {
+		log.Printf("[ROOM %s] recording join of %s failed: %v", room.ID, user.ID, err)
+		return uuid.Nil
+	}
+	return pid
+}
+
+// announceJoin tells the room about a new connection. Under the replace
+// policy the user's older connections are closed wherever they are.
+func (s *WebSocketService) announceJoin(ctx context.Context, c *client, role models.RoomRole, again bool) {
+	kind := protocol.TypeUserJoined
+	if again {
+		if s.settingsOf(c.roomID).DevicePolicy == models.DevicesMultiple {
+			kind = protocol.TypeDeviceJoined
+		} else {
+			_, _ = s.roomRepo.PublishToUser(ctx, c.userID, protocol.SessionReplaced{
+				Envelope: protocol.Event(protocol.TypeSessionReplaced),
+				RoomID:   c.roomID,
+				ConnID:   c.connID,
+			})
 		}
-		_ = conn.WriteJSON(payload)
 	}
+	_ = s.roomRepo.PublishMessage(ctx, c.roomID, presence(kind, c.user, c.connID, role))
+}
 
-	leave := fiberMap(
-		"type", "user-left",
-		"userID", user.ID,
-		"userName", user.UserName,
-		"imgUrl", user.ImgUrl,
-		"sender", userID,
-	)
-	_ = s.roomRepo.PublishMessage(ctx, roomID, leave)
+// leave takes one connection out of the room for good. The user only
+// leaves, and their participation only ends, once none of their
+// connections on any node is left; it reports whether that happened.
+func (s *WebSocketService) leave(ctx context.Context, roomID string, user *models.User, connID string, role models.RoomRole, pid uuid.UUID) bool {
+	userID := user.ID.String()
+	others := s.otherConns(roomID, userID, connID) > 0
+	if !others {
+		_ = s.roomRepo.UntrackPresence(ctx, s.nodeID, roomID, userID)
+		others, _ = s.roomRepo.IsTracked(ctx, repositories.PresenceEntry{RoomID: roomID, UserID: userID})
+	}
+	if others {
+		_ = s.roomRepo.PublishMessage(ctx, roomID, presence(protocol.TypeDeviceLeft, user, connID, role))
+		return false
+	}
+	s.depart(ctx, roomID, user, connID, role, pid)
+	return true
+}
+
+// depart ends the participation of a user no node tracks any more.
+func (s *WebSocketService) depart(ctx context.Context, roomID string, user *models.User, connID string, role models.RoomRole, pid uuid.UUID) {
+	userID := user.ID.String()
+	if pid != uuid.Nil {
+		_ = s.roomRepo.RecordLeave(ctx, pid)
+	}
+	_ = s.roomRepo.RemoveParticipant(ctx, roomID, userID)
+	_ = s.roomRepo.PublishMessage(ctx, roomID, presence(protocol.TypeUserLeft, user, connID, role))
+	if role == models.RoleHost {
+		s.handOffHost(ctx, roomID, userID)
+	}
 }
 
-func (s *WebSocketService) readFromClient(ctx context.Context, conn *websocket.Conn, roomID string, userID string, user *models.User) {
+// readFromClient returns once the peer disconnects, has been silent for
+// longer than the idle timeout, or is cut off for abuse. Pongs count as
+// traffic.
+func (s *WebSocketService) readFromClient(ctx context.Context, c *client) error {
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+	})
+	if s.maxMessageSize > 0 {
+		c.conn.SetReadLimit(s.maxMessageSize)
+	}
 	for {
-		mt, raw, err := conn.ReadMessage()
+		_ = c.conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		mt, raw, err := c.conn.ReadMessage()
+		if errors.Is(err, fastws.ErrReadLimit) {
+			// The connection has already sent a message-too-big close.
+			s.audit(ctx, c, models.AuditOversizeFrame, fmt.Sprintf("frame over %d bytes", s.maxMessageSize))
+			return errAbuse
+		}
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err,
-				websocket.CloseGoingAway,
-				websocket.CloseAbnormalClosure) {
-			}
-			return
+			return err
 		}
-		if mt != websocket.TextMessage {
+		if mt != websocket.TextMessage && mt != websocket.BinaryMessage {
 			continue
 		}
+		if err := s.dispatch(ctx, c, raw); err != nil {
+			return err
+		}
+	}
+}
 
-		var payload map[string]any
-		if json.Unmarshal(raw, &payload) != nil {
+// usersList lists who is already in the room, hiding webinar attendees from
+// those who may not see them.
+func (s *WebSocketService) usersList(ctx context.Context, room *models.Room, userID string) protocol.UsersList {
+	roomID := room.ID.String()
+	seeAll := s.CanSeeAttendees(room, userID)
+	ids, _ := s.roomRepo.GetParticipants(ctx, roomID)
+	list := make([]protocol.UserInfo, 0, len(ids))
+	for _, id := range ids {
+		role := s.RoleOf(roomID, id)
+		if !seeAll && !role.IsPanelist() {
 			continue
 		}
-
-		switch payload["type"] {
-		case "chat-message":
-			s.handleChat(ctx, roomID, userID, payload)
-		case "offer", "answer", "ice-candidate":
-			s.forwardSDP(roomID, userID, payload)
+		if u, _ := s.userRepo.GetUserByID(ctx, id); u != nil {
+			list = append(list, protocol.UserInfo{UserID: id, UserName: u.UserName, ImgUrl: u.ImgUrl, Role: role})
 		}
 	}
+	return protocol.UsersList{Envelope: protocol.Event(protocol.TypeUsersList), Users: list}
 }
 
-func (s *WebSocketService) handleChat(ctx context.Context, roomID string, userID string, raw map[string]any) {
-	msg := raw
-	if inner, ok := raw["message"].(map[string]any); ok {
-		msg = inner
-	}
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
 
-	payload := fiberMap(
-		"type", "chat-message",
-		"id", msg["id"],
-		"text", msg["text"],
-		"time", msg["time"],
-		"user", msg["user"],
-		"sender", userID,
-	)
+func presence(typ string, user *models.User, connID string, role models.RoomRole) protocol.Presence {
+	id := user.ID.String()
+	return protocol.Presence{
+		Envelope: protocol.Envelope{Type: typ, Sender: id},
+		UserInfo: protocol.UserInfo{UserID: id, UserName: user.UserName, ImgUrl: user.ImgUrl, Role: role},
+		ConnID:   connID,
+	}
+}
 
-	_ = s.roomRepo.PublishMessage(ctx, roomID, payload)
+func (s *WebSocketService) chat(ctx context.Context, c *client, m *protocol.ChatSend) error {
+	if !s.settingsOf(c.roomID).ChatEnabled {
+		return errChatDisabled
+	}
+	msg := protocol.Chat{
+		Envelope: protocol.Envelope{Type: protocol.TypeChat, Sender: c.userID},
+		ChatBody: m.Body(),
+	}
+	if err := s.roomRepo.PublishMessage(ctx, c.roomID, msg); err != nil {
+		return err
+	}
+	s.reports.Count(ctx, c.roomID, statChat)
+	return nil
 }
 
-func (s *WebSocketService) forwardSDP(roomID, from string, payload map[string]any) {
-	to, ok := payload["to"].(string)
-	if !ok || to == "" {
-		return
+// forwardSignal hands an offer, answer or candidate to its target directly
+// when the target is connected to this node, and through the target's
+// user channel otherwise.
+func (s *WebSocketService) forwardSignal(ctx context.Context, c *client, m *protocol.SignalSend) error {
+	if !s.canSignal(c.room, c.userID, m.To, m.Type) {
+		return errForbidden
 	}
 
-	s.mutex.RLock()
-	target, exists := s.connections[roomID][to]
-	s.mutex.RUnlock()
-	if !exists {
-		return
+	msg := protocol.Signal{
+		Envelope:  protocol.Envelope{Type: m.Type, Sender: c.userID, Target: m.To},
+		RoomID:    c.roomID,
+		From:      c.userID,
+		FromConn:  c.connID,
+		ToConn:    m.ToConn,
+		Offer:     m.Offer,
+		Answer:    m.Answer,
+		Candidate: m.Candidate,
 	}
 
-	payload["from"] = from
-	payload["sender"] = from
+	var local []*client
+	for _, t := range s.connsOf(c.roomID, m.To) {
+		if m.ToConn == "" || t.connID == m.ToConn {
+			local = append(local, t)
+		}
+	}
+	if len(local) > 0 {
+		for _, t := range local {
+			t.send(msg)
+		}
+		return nil
+	}
 
-	_ = target.WriteJSON(payload)
+	if present, _ := s.roomRepo.IsParticipant(ctx, c.roomID, m.To); !present {
+		return errInvalidTarget
+	}
+	if n, err := s.roomRepo.PublishToUser(ctx, m.To, msg); err != nil {
+		return err
+	} else if n == 0 {
+		return errInvalidTarget
+	}
+	return nil
 }
 
 func (s *WebSocketService) ensureUser(ctx context.Context, uid string) *models.User {
-	if u, err := s.userRepo.GetUserByID(ctx, uid); err == nil {
+	if u, err := s.userRepo.GetUserByID(ctx, uid); err == nil && u != nil {
 		return u
 	}
 
@@ -184,24 +484,80 @@ func (s *WebSocketService) ensureUser(ctx context.Context, uid string) *models.U
 	return user
 }
 
-func (s *WebSocketService) cleanupConnection(ctx context.Context, roomID string, uid string) {
+func (s *WebSocketService) register(c *client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	roomMap, ok := s.connections[c.roomID]
+	if !ok {
+		roomMap = make(map[string]map[string]*client)
+		s.connections[c.roomID] = roomMap
+	}
+	userMap, ok := roomMap[c.userID]
+	if !ok {
+		userMap = make(map[string]*client)
+		roomMap[c.userID] = userMap
+	}
+	userMap[c.connID] = c
+	s.settings[c.roomID] = c.room.Settings
+}
+
+func (s *WebSocketService) connsOf(roomID, userID string) []*client {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	conns := make([]*client, 0, len(s.connections[roomID][userID]))
+	for _, c := range s.connections[roomID][userID] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// otherConns counts the user's connections to roomID on this node besides
+// connID.
+func (s *WebSocketService) otherConns(roomID, userID, connID string) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	n := len(s.connections[roomID][userID])
+	if _, ok := s.connections[roomID][userID][connID]; ok {
+		n--
+	}
+	return n
+}
+
+// cleanupConnection forgets c locally. Leaving the room is up to leave,
+// which knows whether the user is still connected elsewhere.
+func (s *WebSocketService) cleanupConnection(c *client) {
+	roomID, uid := c.roomID, c.userID
 	s.mutex.Lock()
 	if roomMap, ok := s.connections[roomID]; ok {
-		delete(roomMap, uid)
+		delete(roomMap[uid], c.connID)
+		if len(roomMap[uid]) == 0 {
+			delete(roomMap, uid)
+		}
 		if len(roomMap) == 0 {
 			delete(s.connections, roomID)
+			delete(s.roles, roomID)
+			delete(s.settings, roomID)
 		}
 	}
 	s.mutex.Unlock()
-
-	_ = s.roomRepo.RemoveParticipant(ctx, roomID, uid)
-	log.Printf("[ROOM %s] socket closed ← %s", roomID, uid)
+	log.Printf("[ROOM %s] socket closed ← %s (%s)", roomID, uid, c.connID)
 }
 
-func fiberMap(kv ...any) map[string]any {
-	m := make(map[string]any, len(kv)/2)
-	for i := 0; i+1 < len(kv); i += 2 {
-		m[kv[i].(string)] = kv[i+1]
+// RefreshPresence marks every locally connected user as still alive so the
+// janitor does not mistake them for dead sockets.
+func (s *WebSocketService) RefreshPresence(ctx context.Context) {
+	s.mutex.RLock()
+	snapshot := make(map[string][]string, len(s.connections))
+	for roomID, roomMap := range s.connections {
+		for userID := range roomMap {
+			snapshot[roomID] = append(snapshot[roomID], userID)
+		}
+	}
+	s.mutex.RUnlock()
+
+	for roomID, userIDs := range snapshot {
+		if err := s.roomRepo.TouchParticipants(ctx, roomID, userIDs); err != nil {
+			log.Printf("[ROOM %s] presence refresh failed: %v", roomID, err)
+		}
 	}
-	return m
 }